@@ -0,0 +1,681 @@
+package main
+
+import (
+	"fmt"      // Message formatting
+	"hash/fnv" // Alert identifiers
+	"net/http" // HTTP server functionalities
+	"path"     // Namespace patterns
+	"regexp"   // Message patterns and workload names
+	"sort"     // Ordering alerts
+	"strings"  // String manipulation
+	"sync"     // Mutual exclusion
+	"time"     // For time-related operations
+
+	v1 "k8s.io/api/core/v1"          // Core v1 API for Kubernetes
+	"k8s.io/apimachinery/pkg/fields" // For selecting Kubernetes fields
+	"k8s.io/apimachinery/pkg/watch"  // Watch event types
+	"k8s.io/client-go/kubernetes"    // Kubernetes client
+	"k8s.io/client-go/tools/cache"   // For caching Kubernetes objects
+)
+
+// Alert states
+const (
+	alertPending  = "pending"  // Threshold crossed, waiting for the rule's for duration
+	alertFiring   = "firing"   // Delivered to the outputs, waiting for the resolution condition
+	alertResolved = "resolved" // Resolution condition met
+)
+
+// Alert grouping modes: the events counted together by a rule
+const (
+	alertGroupCluster   = "cluster"   // Every matching event of a cluster
+	alertGroupNamespace = "namespace" // Events of one namespace
+	alertGroupWorkload  = "workload"  // Events of one workload, such as the pods of a Deployment
+	alertGroupObject    = "object"    // Events of one object
+)
+
+// Alert severities
+var alertSeverities = map[string]bool{"critical": true, "warning": true, "info": true}
+
+// Event type of the alert notifications delivered to the outputs
+const alertEventType = "ALERT"
+
+// Characters of generated names, such as pod template hashes and pod name suffixes
+const nameAlphabet = "bcdfghjklmnpqrstvwxz2456789"
+
+// Pod names of ReplicaSets, of other controllers, and of StatefulSets
+var (
+	replicaSetPodName  = regexp.MustCompile(`^(.+)-[` + nameAlphabet + `]{6,10}-[` + nameAlphabet + `]{5}$`)
+	generatedPodName   = regexp.MustCompile(`^(.+)-[` + nameAlphabet + `]{5}$`)
+	statefulSetPodName = regexp.MustCompile(`^(.+)-[0-9]+$`)
+	replicaSetName     = regexp.MustCompile(`^(.+)-[` + nameAlphabet + `]{6,10}$`)
+)
+
+// Alert engine of the watched clusters, nil when not watching
+var alerting *alertEngine
+
+// Alert is an alert raised by an alert rule, as listed on /api/v1/alerts and
+// attached to alert notifications
+type Alert struct {
+	ID          string     `json:"id"`                   // Identifier of this occurrence of the alert
+	Rule        string     `json:"rule"`                 // Name of the rule
+	State       string     `json:"state"`                // pending, firing or resolved
+	Severity    string     `json:"severity"`             // Severity of the rule
+	Cluster     string     `json:"cluster"`              // Cluster of the events
+	Namespace   string     `json:"namespace"`            // Namespace of the events, empty for cluster-wide alerts
+	Group       string     `json:"group"`                // Cluster, namespace, workload or object the events are about
+	Count       int        `json:"count"`                // Matching events within the window
+	Kind        string     `json:"kind"`                 // Kind of the object of the last matching event
+	Reason      string     `json:"reason"`               // Reason of the last matching event
+	Message     string     `json:"message"`              // Message of the last matching event
+	ActiveAt    time.Time  `json:"activeAt"`             // When the threshold was crossed
+	FiredAt     *time.Time `json:"firedAt,omitempty"`    // When the alert started firing
+	LastEventAt time.Time  `json:"lastEventAt"`          // When the last matching event occurred
+	ResolvedAt  *time.Time `json:"resolvedAt,omitempty"` // When the alert was resolved
+	Resolution  string     `json:"resolution,omitempty"` // Why the alert was resolved
+	Silenced    bool       `json:"silenced"`             // Whether its notifications were suppressed by a silence
+	SilencedBy  []string   `json:"silencedBy,omitempty"` // Silences and maintenance windows matching the alert
+
+	delivered bool // Whether the firing notification was delivered
+}
+
+// alertRule is a compiled alert rule
+type alertRule struct {
+	config   AlertRule      // Rule as configured
+	message  *regexp.Regexp // Pattern of matching messages, nil for any
+	severity string         // Severity of the alerts
+	groupBy  string         // Grouping mode
+	window   time.Duration  // Window the events are counted in
+	quietFor time.Duration  // Time without matching events resolving an alert
+}
+
+// alertGroup holds the matching events of one group of a rule and its active alert
+type alertGroup struct {
+	rule        *alertRule  // Rule counting the events
+	cluster     string      // Cluster of the events
+	namespace   string      // Namespace of the events
+	group       string      // Group of the events
+	occurrences []time.Time // Times of the matching events within the window
+	lastEventAt time.Time   // Time of the last matching event
+	kind        string      // Kind of the object of the last matching event
+	reason      string      // Reason of the last matching event
+	message     string      // Message of the last matching event
+	alert       *Alert      // Pending or firing alert, nil when inactive
+}
+
+// alertEngine counts the events matching the alert rules, moves alerts through
+// their lifecycle and delivers notifications when they fire and resolve
+type alertEngine struct {
+	rules     []*alertRule  // Compiled rules
+	silences  *silenceStore // Silences suppressing notifications, nil for none
+	publish   func(Event)   // Receiver of the alert notifications
+	interval  time.Duration // Interval of the evaluations
+	retention time.Duration // How long resolved alerts stay listed
+
+	mu       sync.Mutex             // Guards the fields below
+	groups   map[string]*alertGroup // Groups by rule, cluster and group
+	seen     map[string]time.Time   // Occurrence time of the events already counted, by event and version
+	resolved []*Alert               // Resolved alerts, oldest first
+}
+
+// newAlertEngine compiles the alert rules. publish receives the notifications
+// not suppressed by silences.
+func newAlertEngine(config AlertConfig, silences *silenceStore, publish func(Event)) (*alertEngine, error) {
+	e := &alertEngine{
+		silences:  silences,
+		publish:   publish,
+		interval:  config.EvaluationInterval.Duration,
+		retention: config.ResolvedRetention.Duration,
+		groups:    map[string]*alertGroup{},
+		seen:      map[string]time.Time{},
+	}
+	names := map[string]bool{}
+	for _, rule := range config.Rules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("alert rules need a name")
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("duplicate alert rule %q", rule.Name)
+		}
+		names[rule.Name] = true
+		compiled, err := compileAlertRule(rule)
+		if err != nil {
+			return nil, fmt.Errorf("alert rule %q: %w", rule.Name, err)
+		}
+		e.rules = append(e.rules, compiled)
+	}
+	return e, nil
+}
+
+// compileAlertRule checks a rule and applies its defaults
+func compileAlertRule(rule AlertRule) (*alertRule, error) {
+	compiled := &alertRule{
+		config:   rule,
+		severity: rule.Severity,
+		groupBy:  rule.GroupBy,
+		window:   rule.Window.Duration,
+		quietFor: rule.Resolve.QuietFor.Duration,
+	}
+	if compiled.severity == "" {
+		compiled.severity = "warning"
+	}
+	if !alertSeverities[compiled.severity] {
+		return nil, fmt.Errorf("unknown severity %q", rule.Severity)
+	}
+	if compiled.groupBy == "" {
+		compiled.groupBy = alertGroupWorkload
+	}
+	switch compiled.groupBy {
+	case alertGroupCluster, alertGroupNamespace, alertGroupWorkload, alertGroupObject:
+	default:
+		return nil, fmt.Errorf("unknown grouping %q", rule.GroupBy)
+	}
+	// Any pod of a namespace or cluster becoming Ready says nothing about the others
+	if rule.Resolve.PodReady && compiled.groupBy != alertGroupWorkload && compiled.groupBy != alertGroupObject {
+		return nil, fmt.Errorf("resolve.podReady needs the workload or object grouping, not %q", compiled.groupBy)
+	}
+	if rule.Threshold < 0 || compiled.window < 0 || rule.For.Duration < 0 || compiled.quietFor < 0 {
+		return nil, fmt.Errorf("threshold and durations must not be negative")
+	}
+	if compiled.window == 0 {
+		compiled.window = 10 * time.Minute
+	}
+	if compiled.quietFor == 0 {
+		compiled.quietFor = compiled.window
+	}
+	if rule.Match.Severity != "" && rule.Match.Severity != v1.EventTypeNormal && rule.Match.Severity != v1.EventTypeWarning {
+		return nil, fmt.Errorf("unknown event severity %q", rule.Match.Severity)
+	}
+	for _, pattern := range rule.Match.Namespaces {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid namespace pattern %q", pattern)
+		}
+	}
+	if rule.Match.Message != "" {
+		message, err := regexp.Compile(rule.Match.Message)
+		if err != nil {
+			return nil, fmt.Errorf("invalid message pattern %q: %w", rule.Match.Message, err)
+		}
+		compiled.message = message
+	}
+	return compiled, nil
+}
+
+// matches reports whether an event is counted by the rule
+func (r *alertRule) matches(event Event) bool {
+	match := r.config.Match
+	return matchesList(match.Clusters, event.Cluster) &&
+		matchesNamespace(match.Namespaces, event.Object.Namespace) &&
+		matchesList(match.Kinds, event.Object.Kind) &&
+		matchesList(match.Reasons, event.Object.Reason) &&
+		(match.Severity == "" || match.Severity == event.Object.Severity) &&
+		(r.message == nil || r.message.MatchString(event.Object.Message))
+}
+
+// resolvedBy reports whether an event resolves the alerts of its group: an event
+// of the objects the rule matches with one of the resolving reasons
+func (r *alertRule) resolvedBy(event Event) bool {
+	return matchesList(r.config.Match.Clusters, event.Cluster) &&
+		matchesNamespace(r.config.Match.Namespaces, event.Object.Namespace) &&
+		matchesList(r.config.Match.Kinds, event.Object.Kind) &&
+		len(r.config.Resolve.Reasons) > 0 && matchesList(r.config.Resolve.Reasons, event.Object.Reason)
+}
+
+// group returns the namespace and group an event is counted in
+func (r *alertRule) group(event Event) (string, string) {
+	namespace := event.Object.Namespace
+	switch r.groupBy {
+	case alertGroupCluster:
+		return "", event.Cluster
+	case alertGroupNamespace:
+		return namespace, namespace
+	case alertGroupObject:
+		return namespace, qualifiedName(namespace, event.Object.Kind+"/"+event.Object.Name)
+	}
+	return namespace, qualifiedName(namespace, workloadName(event.Object.Kind, event.Object.Name))
+}
+
+// qualifiedName prefixes a name with its namespace, unless it is cluster-scoped
+func qualifiedName(namespace, name string) string {
+	if namespace == "" {
+		return name
+	}
+	return namespace + "/" + name
+}
+
+// matchesList reports whether value is one of values, any value when empty
+func matchesList(values []string, value string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, item := range values {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesNamespace reports whether a namespace matches one of the patterns, any
+// namespace when there are none
+func matchesNamespace(patterns []string, namespace string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, namespace); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// workloadName guesses the workload an object belongs to from its generated name,
+// since events do not carry owners: pods of a Deployment, DaemonSet, Job or
+// StatefulSet and ReplicaSets are named after their workload
+func workloadName(kind, name string) string {
+	var patterns []*regexp.Regexp
+	switch kind {
+	case "Pod":
+		patterns = []*regexp.Regexp{replicaSetPodName, generatedPodName, statefulSetPodName}
+	case "ReplicaSet":
+		patterns = []*regexp.Regexp{replicaSetName}
+	}
+	for _, pattern := range patterns {
+		if match := pattern.FindStringSubmatch(name); match != nil {
+			return match[1]
+		}
+	}
+	return name
+}
+
+// occurrenceTime returns when an event last occurred
+func occurrenceTime(event *v1.Event) time.Time {
+	switch {
+	case !event.LastTimestamp.IsZero():
+		return event.LastTimestamp.Time
+	case !event.EventTime.IsZero():
+		return event.EventTime.Time
+	case !event.FirstTimestamp.IsZero():
+		return event.FirstTimestamp.Time
+	}
+	return time.Now()
+}
+
+// handle is the event handler counting new and updated Kubernetes events. Events
+// listed again after a watch restart are only counted once per version.
+func (e *alertEngine) handle(cluster string, eventType watch.EventType, event *v1.Event) {
+	if eventType == watch.Deleted {
+		return
+	}
+	e.observe(translateEvent(cluster, string(eventType), event), occurrenceTime(event), time.Now(),
+		cluster+"/"+event.Namespace+"/"+event.Name+"/"+event.ResourceVersion)
+}
+
+// observe counts an event that occurred at the given time in the groups of the
+// rules it matches, and resolves the alerts of the rules it resolves, at now
+func (e *alertEngine) observe(event Event, at, now time.Time, key string) {
+	var notifications []Alert
+
+	e.mu.Lock()
+	if _, ok := e.seen[key]; ok {
+		e.mu.Unlock()
+		return
+	}
+	e.seen[key] = at
+
+	for _, rule := range e.rules {
+		namespace, group := rule.group(event)
+		id := rule.config.Name + "|" + event.Cluster + "|" + group
+		g := e.groups[id]
+
+		if rule.resolvedBy(event) {
+			if g != nil {
+				if alert := e.resolve(g, now, "resolved by "+event.Object.Reason+" event"); alert != nil {
+					notifications = append(notifications, *alert)
+				}
+			}
+			continue
+		}
+		if !rule.matches(event) || now.Sub(at) > rule.window {
+			continue
+		}
+
+		if g == nil {
+			g = &alertGroup{rule: rule, cluster: event.Cluster, namespace: namespace, group: group}
+			e.groups[id] = g
+		}
+		g.occurrences = append(g.occurrences, at)
+		g.prune(now)
+		if at.After(g.lastEventAt) {
+			g.lastEventAt = at
+			g.kind, g.reason, g.message = event.Object.Kind, event.Object.Reason, event.Object.Message
+		}
+		if alert := e.update(g, now); alert != nil {
+			notifications = append(notifications, *alert)
+		}
+	}
+	e.mu.Unlock()
+
+	e.notify(notifications)
+}
+
+// prune drops the occurrences that left the window
+func (g *alertGroup) prune(now time.Time) {
+	kept := g.occurrences[:0]
+	for _, at := range g.occurrences {
+		if now.Sub(at) <= g.rule.window {
+			kept = append(kept, at)
+		}
+	}
+	g.occurrences = kept
+}
+
+// update moves the alert of a group forward: raises it when the threshold is
+// crossed, fires it once pending for long enough and drops it when the events
+// fall back under the threshold before it fired. It returns the alert when its
+// firing notification is to be delivered: when it fires unsilenced, or when the
+// silences of an alert that fired silenced end.
+func (e *alertEngine) update(g *alertGroup, now time.Time) *Alert {
+	crossed := len(g.occurrences) > g.rule.config.Threshold
+	if g.alert == nil {
+		if !crossed {
+			return nil
+		}
+		g.alert = &Alert{
+			ID:        alertID(g, now),
+			Rule:      g.rule.config.Name,
+			State:     alertPending,
+			Severity:  g.rule.severity,
+			Cluster:   g.cluster,
+			Namespace: g.namespace,
+			Group:     g.group,
+			ActiveAt:  now,
+		}
+	}
+	g.alert.Count = len(g.occurrences)
+	g.alert.Kind, g.alert.Reason, g.alert.Message = g.kind, g.reason, g.message
+	g.alert.LastEventAt = g.lastEventAt
+
+	if g.alert.State == alertPending {
+		if !crossed {
+			g.alert = nil
+			return nil
+		}
+		if now.Sub(g.alert.ActiveAt) < g.rule.config.For.Duration {
+			return nil
+		}
+		g.alert.State = alertFiring
+		g.alert.FiredAt = &now
+	}
+
+	e.silence(g.alert, now)
+	if g.alert.SilencedBy == nil && !g.alert.delivered {
+		g.alert.delivered = true
+		return g.alert
+	}
+	return nil
+}
+
+// silence records the silences matching an alert. An alert is marked silenced
+// once a silence matched it, even after the silence ended.
+func (e *alertEngine) silence(alert *Alert, now time.Time) {
+	alert.SilencedBy = e.silences.matching(alert, now)
+	if alert.SilencedBy != nil {
+		alert.Silenced = true
+	}
+}
+
+// resolve ends the alert of a group and forgets its events. It returns the
+// alert when its resolution is to be delivered: when its firing was delivered
+// and it is not silenced. Pending alerts are dropped silently.
+func (e *alertEngine) resolve(g *alertGroup, now time.Time, resolution string) *Alert {
+	alert := g.alert
+	g.alert = nil
+	g.occurrences = nil
+	if alert == nil || alert.State != alertFiring {
+		return nil
+	}
+	alert.State = alertResolved
+	alert.ResolvedAt = &now
+	alert.Resolution = resolution
+	e.resolved = append(e.resolved, alert)
+	e.silence(alert, now)
+	if !alert.delivered || alert.SilencedBy != nil {
+		return nil
+	}
+	return alert
+}
+
+// alertID returns the identifier of a new alert of a group
+func alertID(g *alertGroup, now time.Time) string {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s|%s|%s|%d", g.rule.config.Name, g.cluster, g.group, now.UnixNano())
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// run evaluates the alerts at every interval until stop is closed
+func (e *alertEngine) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			e.evaluate(now)
+		case <-stop:
+			return
+		}
+	}
+}
+
+// evaluate moves pending alerts forward, resolves firing alerts of quiet groups
+// and forgets old events, empty groups and expired resolved alerts
+func (e *alertEngine) evaluate(now time.Time) {
+	var notifications []Alert
+
+	e.mu.Lock()
+	for id, g := range e.groups {
+		g.prune(now)
+		if alert := e.update(g, now); alert != nil {
+			notifications = append(notifications, *alert)
+		}
+		if g.alert != nil && g.alert.State == alertFiring && now.Sub(g.lastEventAt) >= g.rule.quietFor {
+			if alert := e.resolve(g, now, fmt.Sprintf("no matching events for %s", g.rule.quietFor)); alert != nil {
+				notifications = append(notifications, *alert)
+			}
+		}
+		if g.alert == nil && len(g.occurrences) == 0 {
+			delete(e.groups, id)
+		}
+	}
+
+	var longest time.Duration
+	for _, rule := range e.rules {
+		if rule.window > longest {
+			longest = rule.window
+		}
+	}
+	for key, at := range e.seen {
+		if now.Sub(at) > longest {
+			delete(e.seen, key)
+		}
+	}
+
+	kept := e.resolved[:0]
+	for _, alert := range e.resolved {
+		if now.Sub(*alert.ResolvedAt) < e.retention {
+			kept = append(kept, alert)
+		}
+	}
+	e.resolved = kept
+	e.mu.Unlock()
+
+	e.notify(notifications)
+}
+
+// podReady resolves the firing alerts of the rules resolved by pods becoming Ready
+// in the group of a pod, at now. Pending alerts keep counting, as a pod starting
+// does not undo the events that made them pending.
+func (e *alertEngine) podReady(cluster string, pod *v1.Pod, now time.Time) {
+	event := Event{Cluster: cluster, Object: Object{Kind: "Pod", Name: pod.Name, Namespace: pod.Namespace}}
+	var notifications []Alert
+
+	e.mu.Lock()
+	for _, rule := range e.rules {
+		if !rule.config.Resolve.PodReady {
+			continue
+		}
+		_, group := rule.group(event)
+		if g := e.groups[rule.config.Name+"|"+cluster+"|"+group]; g != nil && g.alert != nil && g.alert.State == alertFiring {
+			if alert := e.resolve(g, now, "pod "+pod.Name+" became Ready"); alert != nil {
+				notifications = append(notifications, *alert)
+			}
+		}
+	}
+	e.mu.Unlock()
+
+	e.notify(notifications)
+}
+
+// watchesReadiness reports whether a rule is resolved by pods becoming Ready
+func (e *alertEngine) watchesReadiness() bool {
+	for _, rule := range e.rules {
+		if rule.config.Resolve.PodReady {
+			return true
+		}
+	}
+	return false
+}
+
+// notify delivers notifications of alerts that fired or resolved to the outputs
+func (e *alertEngine) notify(alerts []Alert) {
+	for i := range alerts {
+		e.publish(alertEvent(&alerts[i]))
+	}
+}
+
+// alertEvent returns the notification of an alert, an event of type ALERT about
+// the rule in the namespace of the alert
+func alertEvent(alert *Alert) Event {
+	reason, severity, at := "AlertFiring", v1.EventTypeWarning, alert.ActiveAt
+	message := fmt.Sprintf("%s alert %s firing for %s: %d matching events, last: %s",
+		alert.Severity, alert.Rule, alert.Group, alert.Count, alert.Message)
+	switch alert.State {
+	case alertPending:
+		reason = "AlertPending"
+		message = fmt.Sprintf("%s alert %s pending for %s: %d matching events", alert.Severity, alert.Rule, alert.Group, alert.Count)
+	case alertFiring:
+		at = *alert.FiredAt
+	case alertResolved:
+		reason, severity, at = "AlertResolved", v1.EventTypeNormal, *alert.ResolvedAt
+		message = fmt.Sprintf("%s alert %s resolved for %s: %s", alert.Severity, alert.Rule, alert.Group, alert.Resolution)
+	}
+	return Event{
+		Type:    alertEventType,
+		Cluster: alert.Cluster,
+		Object: Object{
+			Kind:      "Alert",
+			Name:      alert.Rule,
+			Namespace: alert.Namespace,
+			Reason:    reason,
+			Severity:  severity,
+			Message:   message,
+		},
+		Timestamp: at.Format(timestampLayout),
+		Alert:     alert,
+	}
+}
+
+// list returns copies of the active alerts and of the resolved alerts still
+// retained, firing first, then pending, then resolved, most recent first
+func (e *alertEngine) list() []Alert {
+	e.mu.Lock()
+	alerts := []Alert{}
+	for _, g := range e.groups {
+		if g.alert != nil {
+			alerts = append(alerts, *g.alert)
+		}
+	}
+	for _, alert := range e.resolved {
+		alerts = append(alerts, *alert)
+	}
+	e.mu.Unlock()
+
+	order := map[string]int{alertFiring: 0, alertPending: 1, alertResolved: 2}
+	sort.Slice(alerts, func(i, j int) bool {
+		if order[alerts[i].State] != order[alerts[j].State] {
+			return order[alerts[i].State] < order[alerts[j].State]
+		}
+		return alerts[i].ActiveAt.After(alerts[j].ActiveAt)
+	})
+	return alerts
+}
+
+// handleAlerts serves the alerts the client may see, filtered like /ws
+// subscriptions and by ?state=firing,pending,resolved
+func handleAlerts(w http.ResponseWriter, r *http.Request, engine *alertEngine, authz namespaceAuthorizer) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	sub := parseSubscription(r)
+	sub.Types = nil
+	if !authorizeRequest(r.Context(), w, r, authz, &sub) {
+		return
+	}
+	states := parseFilterValues(r.URL.Query()["state"])
+
+	alerts := []Alert{}
+	for _, alert := range engine.list() {
+		if matchesFilter(states, alert.State) && sub.matches(alertEvent(&alert)) {
+			alerts = append(alerts, alert)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, alerts)
+}
+
+// alertReadinessTracker reports pods becoming Ready to the alert engine, for the
+// rules resolved by readiness
+type alertReadinessTracker struct {
+	engine *alertEngine // Engine resolving the alerts
+}
+
+// start adds a handler to the pod informer of every watched namespace, shared with
+// the trackers
+func (t *alertReadinessTracker) start(w *clusterWatcher, clientset kubernetes.Interface, stop <-chan struct{}) []cache.InformerSynced {
+	var synced []cache.InformerSynced
+	for _, namespace := range w.namespaces {
+		lw := cache.NewListWatchFromClient(clientset.CoreV1().RESTClient(), "pods", namespace, fields.Everything())
+		nw := w.sharedInformer("pods", namespace, lw, &v1.Pod{}, 0, cache.ResourceEventHandlerFuncs{
+			UpdateFunc: func(old, obj interface{}) {
+				oldPod, ok1 := old.(*v1.Pod)
+				pod, ok2 := obj.(*v1.Pod)
+				if ok1 && ok2 && !podIsReady(oldPod) && podIsReady(pod) {
+					t.engine.podReady(w.name, pod, time.Now())
+				}
+			},
+		}, false)
+		synced = append(synced, nw.informer.HasSynced)
+	}
+	return synced
+}
+
+// podIsReady reports whether the Ready condition of a pod is true
+func podIsReady(pod *v1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == v1.PodReady {
+			return condition.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// describeAlertRules lists the rule names, for logging
+func describeAlertRules(rules []*alertRule) string {
+	names := make([]string, 0, len(rules))
+	for _, rule := range rules {
+		names = append(names, rule.config.Name)
+	}
+	return strings.Join(names, ", ")
+}
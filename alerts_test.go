@@ -0,0 +1,238 @@
+package main
+
+import (
+	"fmt"     // Event keys
+	"testing" // Test framework
+	"time"    // For time-related operations
+
+	v1 "k8s.io/api/core/v1"                       // Core v1 API for Kubernetes
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1" // Meta v1 API for Kubernetes
+)
+
+// Start of the test timelines
+var alertT0 = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// testAlerts drives an alert engine and records its notifications
+type testAlerts struct {
+	t             *testing.T   // Test using the engine
+	engine        *alertEngine // Engine under test
+	notifications []Event      // Notifications published so far
+	events        int          // Events observed, for unique keys
+}
+
+// newTestAlerts compiles a single rule, with the given silences
+func newTestAlerts(t *testing.T, silences *silenceStore, rule AlertRule) *testAlerts {
+	t.Helper()
+	a := &testAlerts{t: t}
+	engine, err := newAlertEngine(AlertConfig{Rules: []AlertRule{rule}, ResolvedRetention: metav1.Duration{Duration: time.Hour}},
+		silences, func(event Event) { a.notifications = append(a.notifications, event) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.engine = engine
+	return a
+}
+
+// observe feeds an event of an object of the prod namespace that occurred at now
+func (a *testAlerts) observe(kind, name, reason string, now time.Time) {
+	a.events++
+	event := Event{Cluster: "default", Object: Object{Kind: kind, Name: name, Namespace: "prod", Reason: reason, Severity: v1.EventTypeWarning}}
+	a.engine.observe(event, now, now, fmt.Sprintf("default/prod/event-%d/1", a.events))
+}
+
+// expect checks the reasons of the notifications published since the last check
+func (a *testAlerts) expect(reasons ...string) {
+	a.t.Helper()
+	var got []string
+	for _, notification := range a.notifications {
+		got = append(got, notification.Object.Reason)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(reasons) {
+		a.t.Fatalf("notifications %v, want %v", got, reasons)
+	}
+	a.notifications = nil
+}
+
+// state returns the state of the only alert listed, empty when there is none
+func (a *testAlerts) state() string {
+	alerts := a.engine.list()
+	if len(alerts) == 0 {
+		return ""
+	}
+	return alerts[0].State
+}
+
+// crashLoop is a rule alerting on more than two BackOff events of a workload
+func crashLoop() AlertRule {
+	return AlertRule{
+		Name:      "crashloop",
+		Match:     AlertMatch{Kinds: []string{"Pod"}, Reasons: []string{"BackOff"}},
+		Threshold: 2,
+		Window:    metav1.Duration{Duration: 10 * time.Minute},
+	}
+}
+
+func TestAlertThreshold(t *testing.T) {
+	a := newTestAlerts(t, nil, crashLoop())
+
+	a.observe("Pod", "api-7d9f8c6b5-x2x4z", "BackOff", alertT0)
+	a.observe("Pod", "api-7d9f8c6b5-bq5wn", "BackOff", alertT0.Add(time.Minute))
+	a.observe("Pod", "api-7d9f8c6b5-x2x4z", "Pulled", alertT0.Add(time.Minute))
+	a.observe("Node", "api", "BackOff", alertT0.Add(time.Minute))
+	a.expect()
+	if state := a.state(); state != "" {
+		t.Fatalf("alert %s under the threshold", state)
+	}
+
+	// The event of another workload is counted apart
+	a.observe("Pod", "web-7d9f8c6b5-x2x4z", "BackOff", alertT0.Add(2*time.Minute))
+	a.expect()
+
+	a.observe("Pod", "api-7d9f8c6b5-x2x4z", "BackOff", alertT0.Add(2*time.Minute))
+	a.expect("AlertFiring")
+	if alert := a.engine.list()[0]; alert.State != alertFiring || alert.Count != 3 || alert.Group != "prod/api" {
+		t.Fatalf("alert %+v, want firing for prod/api with 3 events", alert)
+	}
+
+	// An event listed again after a watch restart is not counted twice
+	a.engine.observe(Event{Cluster: "default", Object: Object{Kind: "Pod", Name: "api-7d9f8c6b5-x2x4z", Namespace: "prod", Reason: "BackOff"}},
+		alertT0, alertT0.Add(3*time.Minute), "default/prod/event-1/1")
+	if count := a.engine.list()[0].Count; count != 3 {
+		t.Fatalf("alert counts %d events after a relist, want 3", count)
+	}
+}
+
+func TestAlertFor(t *testing.T) {
+	rule := crashLoop()
+	rule.For = metav1.Duration{Duration: 5 * time.Minute}
+	a := newTestAlerts(t, nil, rule)
+
+	for i := 0; i < 3; i++ {
+		a.observe("Pod", "api-7d9f8c6b5-x2x4z", "BackOff", alertT0)
+	}
+	a.expect()
+	if state := a.state(); state != alertPending {
+		t.Fatalf("alert %q, want pending", state)
+	}
+	a.engine.evaluate(alertT0.Add(4 * time.Minute))
+	a.expect()
+	a.engine.evaluate(alertT0.Add(5 * time.Minute))
+	a.expect("AlertFiring")
+
+	// A pending alert falling back under the threshold is dropped without notification
+	b := newTestAlerts(t, nil, rule)
+	for i := 0; i < 3; i++ {
+		b.observe("Pod", "api-7d9f8c6b5-x2x4z", "BackOff", alertT0)
+	}
+	b.engine.evaluate(alertT0.Add(4 * time.Minute))
+	b.observe("Pod", "api-7d9f8c6b5-x2x4z", "BackOff", alertT0.Add(11*time.Minute))
+	b.expect()
+	if state := b.state(); state != "" {
+		t.Fatalf("alert %q after the events left the window, want none", state)
+	}
+}
+
+func TestAlertQuietResolution(t *testing.T) {
+	a := newTestAlerts(t, nil, crashLoop())
+	for i := 0; i < 3; i++ {
+		a.observe("Pod", "api-7d9f8c6b5-x2x4z", "BackOff", alertT0.Add(time.Duration(i)*time.Minute))
+	}
+	a.expect("AlertFiring")
+
+	// Quiet for the window by default, counted from the last event
+	a.engine.evaluate(alertT0.Add(11 * time.Minute))
+	a.expect()
+	a.engine.evaluate(alertT0.Add(12 * time.Minute))
+	a.expect("AlertResolved")
+	if alert := a.engine.list()[0]; alert.State != alertResolved || alert.Resolution != "no matching events for 10m0s" {
+		t.Fatalf("alert %+v, want resolved by quietness", alert)
+	}
+}
+
+func TestAlertResolvingReason(t *testing.T) {
+	rule := crashLoop()
+	rule.Resolve.Reasons = []string{"Started"}
+	a := newTestAlerts(t, nil, rule)
+	for i := 0; i < 3; i++ {
+		a.observe("Pod", "api-7d9f8c6b5-x2x4z", "BackOff", alertT0)
+	}
+	a.expect("AlertFiring")
+
+	// Only events of the kinds the rule matches resolve it
+	a.observe("Deployment", "api", "Started", alertT0.Add(time.Minute))
+	a.expect()
+	a.observe("Pod", "api-7d9f8c6b5-bq5wn", "Started", alertT0.Add(time.Minute))
+	a.expect("AlertResolved")
+}
+
+func TestAlertPodReady(t *testing.T) {
+	rule := crashLoop()
+	rule.For = metav1.Duration{Duration: 5 * time.Minute}
+	rule.Resolve.PodReady = true
+	a := newTestAlerts(t, nil, rule)
+	ready := func(name string, now time.Time) {
+		a.engine.podReady("default", &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "prod"}}, now)
+	}
+
+	for i := 0; i < 3; i++ {
+		a.observe("Pod", "api-7d9f8c6b5-x2x4z", "BackOff", alertT0)
+	}
+	// A pod starting does not undo the events of a pending alert
+	ready("api-7d9f8c6b5-bq5wn", alertT0.Add(time.Minute))
+	if state := a.state(); state != alertPending {
+		t.Fatalf("alert %q after a pod became Ready, want pending", state)
+	}
+
+	a.engine.evaluate(alertT0.Add(5 * time.Minute))
+	a.expect("AlertFiring")
+	ready("web-7d9f8c6b5-bq5wn", alertT0.Add(6*time.Minute))
+	a.expect()
+	ready("api-7d9f8c6b5-bq5wn", alertT0.Add(6*time.Minute))
+	a.expect("AlertResolved")
+
+	if _, err := compileAlertRule(AlertRule{Name: "nodes", GroupBy: alertGroupNamespace, Resolve: AlertResolve{PodReady: true}}); err == nil {
+		t.Fatal("podReady accepted with the namespace grouping")
+	}
+}
+
+func TestAlertSilenceDelivery(t *testing.T) {
+	silences, err := newSilenceStore(SilenceConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := silences.add(Silence{Matcher: SilenceMatcher{Rules: []string{"crashloop"}}, StartsAt: alertT0, EndsAt: alertT0.Add(30 * time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+	rule := crashLoop()
+	rule.Window = metav1.Duration{Duration: time.Hour}
+	a := newTestAlerts(t, silences, rule)
+
+	for i := 0; i < 3; i++ {
+		a.observe("Pod", "api-7d9f8c6b5-x2x4z", "BackOff", alertT0)
+	}
+	a.expect()
+	if alert := a.engine.list()[0]; alert.State != alertFiring || !alert.Silenced {
+		t.Fatalf("alert %+v, want firing silenced", alert)
+	}
+	a.engine.evaluate(alertT0.Add(29 * time.Minute))
+	a.expect()
+
+	// Still firing when the silence ends, so it is notified then, and its resolution too
+	a.engine.evaluate(alertT0.Add(30 * time.Minute))
+	a.expect("AlertFiring")
+	a.engine.evaluate(alertT0.Add(31 * time.Minute))
+	a.expect()
+	a.engine.evaluate(alertT0.Add(time.Hour))
+	a.expect("AlertResolved")
+
+	// An alert resolved while silenced is never notified
+	b := newTestAlerts(t, silences, crashLoop())
+	for i := 0; i < 3; i++ {
+		b.observe("Pod", "api-7d9f8c6b5-x2x4z", "BackOff", alertT0)
+	}
+	b.engine.evaluate(alertT0.Add(10 * time.Minute))
+	b.expect()
+	if state := b.state(); state != alertResolved {
+		t.Fatalf("alert %q, want resolved", state)
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"encoding/json" // Baseline persistence
+	"fmt"           // Message formatting
+	"math"          // Standard deviations
+	"os"            // Reading and writing the state file
+	"path/filepath" // Temporary state file
+	"sort"          // Ordering persisted baselines
+	"sync"          // Mutual exclusion
+	"time"          // For time-related operations
+
+	"github.com/sirupsen/logrus"    // Package for structured logging
+	v1 "k8s.io/api/core/v1"         // Core v1 API for Kubernetes
+	"k8s.io/apimachinery/pkg/watch" // Watch event types
+)
+
+// Event type of the anomaly notifications
+const anomalyEventType = "ANOMALY"
+
+// Mean below which the baseline of a key without events is forgotten, in events per interval
+const anomalyForgetMean = 0.01
+
+// Directions of anomalies
+const (
+	anomalySpike = "spike" // Rate above the baseline
+	anomalyDrop  = "drop"  // Rate below the baseline
+)
+
+// AnomalySummary describes an event rate anomaly in anomaly notifications. Rates
+// are in events per minute.
+type AnomalySummary struct {
+	Reason    string  `json:"reason"`    // Reason of the events
+	Direction string  `json:"direction"` // spike or drop
+	Observed  float64 `json:"observed"`  // Rate over the last interval
+	Expected  float64 `json:"expected"`  // Rate expected from the baseline
+	StdDev    float64 `json:"stdDev"`    // Standard deviation of the rate used for the score
+	Score     float64 `json:"score"`     // Standard deviations between the observed and expected rates, negative for drops
+	Interval  string  `json:"interval"`  // Interval the rate was observed over
+}
+
+// anomalyKey identifies the events whose rate is learned together
+type anomalyKey struct {
+	cluster   string // Cluster of the events
+	namespace string // Namespace of the involved objects, empty for cluster-scoped objects
+	reason    string // Reason of the events
+}
+
+// baseline is the learned rate of a key: exponentially weighted moving average
+// and variance of its event count per interval
+type baseline struct {
+	Mean     float64 `json:"mean"`     // Average events per interval
+	Variance float64 `json:"variance"` // Variance of the events per interval
+	Samples  int     `json:"samples"`  // Intervals learned
+}
+
+// anomalyState is the content of the state file
+type anomalyState struct {
+	UpdatedAt time.Time           `json:"updatedAt"` // When the state was saved
+	Interval  string              `json:"interval"`  // Interval the baselines count events over
+	Baselines []persistedBaseline `json:"baselines"` // Learned baselines
+}
+
+// persistedBaseline is a baseline with its key, as stored in the state file
+type persistedBaseline struct {
+	Cluster   string `json:"cluster"`   // Cluster of the events
+	Namespace string `json:"namespace"` // Namespace of the involved objects
+	Reason    string `json:"reason"`    // Reason of the events
+	baseline
+}
+
+// anomalyDetector learns the event rate of every namespace and reason, and
+// reports intervals whose rate is significantly above the learned baseline
+type anomalyDetector struct {
+	config  AnomalyConfig // Detection settings
+	publish func(Event)   // Receiver of the anomaly notifications
+
+	mu        sync.Mutex               // Guards the fields below
+	counts    map[anomalyKey]int       // Events of the current interval
+	baselines map[anomalyKey]*baseline // Learned baselines
+	seen      map[string]time.Time     // Occurrence time of the events already counted, by event and version
+}
+
+// newAnomalyDetector creates a detector publishing its notifications to publish,
+// restoring the baselines of the state file when there is one
+func newAnomalyDetector(config AnomalyConfig, publish func(Event)) *anomalyDetector {
+	d := &anomalyDetector{
+		config:    config,
+		publish:   publish,
+		counts:    map[anomalyKey]int{},
+		baselines: map[anomalyKey]*baseline{},
+		seen:      map[string]time.Time{},
+	}
+	if config.StateFile != "" {
+		if err := d.load(); err != nil && !os.IsNotExist(err) {
+			log.WithFields(logrus.Fields{"file": config.StateFile, "error": err}).Warning("Failed to load anomaly baselines, learning from scratch")
+		}
+	}
+	return d
+}
+
+// handle is the event handler counting new and updated Kubernetes events. Events
+// that occurred before the current interval, such as those listed on startup, are
+// not counted, and events listed again after a watch restart are only counted
+// once per version.
+func (d *anomalyDetector) handle(cluster string, eventType watch.EventType, event *v1.Event) {
+	at := occurrenceTime(event)
+	if eventType == watch.Deleted || time.Since(at) > d.config.Interval.Duration {
+		return
+	}
+	key := anomalyKey{cluster: cluster, namespace: event.InvolvedObject.Namespace, reason: event.Reason}
+	version := cluster + "/" + event.Namespace + "/" + event.Name + "/" + event.ResourceVersion
+	d.mu.Lock()
+	if _, ok := d.seen[version]; !ok {
+		d.seen[version] = at
+		d.counts[key]++
+	}
+	d.mu.Unlock()
+}
+
+// run evaluates the rates at every interval until stop is closed
+func (d *anomalyDetector) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(d.config.Interval.Duration)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			d.evaluate()
+			d.persist()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// evaluate scores the event count of the interval that just ended against the
+// baseline of every key, reports the anomalies and learns the counts
+func (d *anomalyDetector) evaluate() {
+	minutes := d.config.Interval.Duration.Minutes()
+	now := time.Now()
+	var notifications []Event
+
+	d.mu.Lock()
+	for key := range d.counts {
+		if d.baselines[key] == nil {
+			d.baselines[key] = &baseline{}
+		}
+	}
+	for key, b := range d.baselines {
+		count := float64(d.counts[key])
+		// Drops are only scored when the baseline is above the minimum rate
+		if b.Samples >= d.config.MinSamples && math.Max(count, b.Mean)/minutes >= d.config.MinRate {
+			// Counts are at least Poisson distributed, which keeps keys with a steady
+			// rate from scoring huge deviations on their first change
+			stdDev := math.Max(math.Sqrt(b.Variance), math.Max(math.Sqrt(b.Mean), 1))
+			if score := (count - b.Mean) / stdDev; math.Abs(score) >= d.config.Threshold {
+				direction := anomalySpike
+				if score < 0 {
+					direction = anomalyDrop
+				}
+				notifications = append(notifications, d.notification(key, AnomalySummary{
+					Reason:    key.reason,
+					Direction: direction,
+					Observed:  round(count / minutes),
+					Expected:  round(b.Mean / minutes),
+					StdDev:    round(stdDev / minutes),
+					Score:     round(score),
+					Interval:  d.config.Interval.Duration.String(),
+				}))
+			}
+		}
+		b.learn(count, d.config.Alpha)
+		if count == 0 && b.Samples >= d.config.MinSamples && b.Mean < anomalyForgetMean {
+			delete(d.baselines, key)
+		}
+	}
+	d.counts = map[anomalyKey]int{}
+	for version, at := range d.seen {
+		if now.Sub(at) > d.config.Interval.Duration {
+			delete(d.seen, version)
+		}
+	}
+	d.mu.Unlock()
+
+	for _, notification := range notifications {
+		d.publish(notification)
+	}
+}
+
+// learn updates the moving average and variance with the count of an interval
+func (b *baseline) learn(count, alpha float64) {
+	if b.Samples == 0 {
+		b.Mean = count
+	} else {
+		diff := count - b.Mean
+		increment := alpha * diff
+		b.Mean += increment
+		b.Variance = (1 - alpha) * (b.Variance + diff*increment)
+	}
+	b.Samples++
+}
+
+// notification returns the anomaly notification of a key
+func (d *anomalyDetector) notification(key anomalyKey, summary AnomalySummary) Event {
+	scope := "in namespace " + key.namespace
+	if key.namespace == "" {
+		scope = "of cluster-scoped objects"
+	}
+	change := "at"
+	if summary.Direction == anomalyDrop {
+		change = "dropped to"
+	}
+	return Event{
+		Type:    anomalyEventType,
+		Cluster: key.cluster,
+		Object: Object{
+			Kind:      "Namespace",
+			Name:      key.namespace,
+			Namespace: key.namespace,
+			Reason:    "EventRateAnomaly",
+			Severity:  v1.EventTypeWarning,
+			Message: fmt.Sprintf("%s events %s %s %.1f/min over the last %s, expected %.1f/min (score %.1f)",
+				key.reason, scope, change, summary.Observed, summary.Interval, summary.Expected, summary.Score),
+		},
+		Timestamp: time.Now().Format(timestampLayout),
+		Anomaly:   &summary,
+	}
+}
+
+// round rounds a value to two decimals for notifications
+func round(value float64) float64 {
+	return math.Round(value*100) / 100
+}
+
+// load restores the baselines of the state file. Baselines learned over another
+// interval are scaled to the configured one.
+func (d *anomalyDetector) load() error {
+	data, err := os.ReadFile(d.config.StateFile)
+	if err != nil {
+		return err
+	}
+	var state anomalyState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	scale := 1.0
+	if interval, err := time.ParseDuration(state.Interval); err == nil && interval > 0 {
+		scale = float64(d.config.Interval.Duration) / float64(interval)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, persisted := range state.Baselines {
+		b := persisted.baseline
+		b.Mean *= scale
+		// Counts scale linearly with the interval, so their variance scales quadratically
+		b.Variance *= scale * scale
+		d.baselines[anomalyKey{cluster: persisted.Cluster, namespace: persisted.Namespace, reason: persisted.Reason}] = &b
+	}
+	log.WithFields(logrus.Fields{"file": d.config.StateFile, "baselines": len(state.Baselines), "updatedAt": state.UpdatedAt.Format(time.RFC3339)}).
+		Info("Anomaly baselines restored")
+	return nil
+}
+
+// persist saves the baselines when a state file is configured, logging failures
+func (d *anomalyDetector) persist() {
+	if d.config.StateFile == "" {
+		return
+	}
+	if err := d.save(); err != nil {
+		log.WithFields(logrus.Fields{"file": d.config.StateFile, "error": err}).Warning("Failed to save anomaly baselines")
+	}
+}
+
+// save writes the baselines to the state file, replacing it atomically
+func (d *anomalyDetector) save() error {
+	state := anomalyState{UpdatedAt: time.Now().UTC(), Interval: d.config.Interval.Duration.String(), Baselines: []persistedBaseline{}}
+	d.mu.Lock()
+	for key, b := range d.baselines {
+		state.Baselines = append(state.Baselines, persistedBaseline{Cluster: key.cluster, Namespace: key.namespace, Reason: key.reason, baseline: *b})
+	}
+	d.mu.Unlock()
+	sort.Slice(state.Baselines, func(i, j int) bool {
+		a, b := state.Baselines[i], state.Baselines[j]
+		if a.Cluster != b.Cluster {
+			return a.Cluster < b.Cluster
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Reason < b.Reason
+	})
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(d.config.StateFile), filepath.Base(d.config.StateFile)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), d.config.StateFile)
+}
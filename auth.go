@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"         // Request contexts
+	"crypto/sha256"   // Hashing tokens for the cache
+	"encoding/base64" // Decoding subprotocol tokens
+	"encoding/hex"    // Formatting token hashes
+	"errors"          // Error values
+	"fmt"             // Error formatting
+	"net/http"        // HTTP server functionalities
+	"strings"         // String manipulation
+
+	"github.com/sirupsen/logrus"                    // Package for structured logging
+	authenticationv1 "k8s.io/api/authentication/v1" // TokenReview API
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"   // Meta v1 API for Kubernetes
+	"k8s.io/client-go/kubernetes"                   // Kubernetes client
+)
+
+// Authentication modes
+const (
+	authModeNone        = ""            // No authentication
+	authModeTokenReview = "tokenreview" // Kubernetes TokenReview API
+	authModeOIDC        = "oidc"        // OIDC ID tokens validated against the issuer's keys
+)
+
+// WebSocket subprotocol carrying a bearer token, as used by the Kubernetes API server
+const bearerSubprotocolPrefix = "base64url.bearer.authorization.k8s.io."
+
+// WebSocket subprotocol selected for clients that send their token as a subprotocol
+const eventsSubprotocol = "translator.events.v1"
+
+// errUnauthenticated is returned when a token is missing or rejected
+var errUnauthenticated = errors.New("unauthenticated")
+
+// userInfo is the identity of an authenticated client
+type userInfo struct {
+	Username string   `json:"username"` // Authenticated user name
+	UID      string   `json:"uid"`      // Unique user identifier
+	Groups   []string `json:"groups"`   // Groups the user belongs to
+}
+
+// fields returns the identity as log fields
+func (u *userInfo) fields() logrus.Fields {
+	return logrus.Fields{"user": u.Username, "groups": u.Groups}
+}
+
+// tokenAuthenticator validates bearer tokens
+type tokenAuthenticator interface {
+	authenticate(ctx context.Context, token string) (*userInfo, error)
+}
+
+// userContextKey is the request context key of the authenticated user
+type userContextKey struct{}
+
+// requestUser returns the authenticated user of a request, nil when authentication is disabled
+func requestUser(r *http.Request) *userInfo {
+	user, _ := r.Context().Value(userContextKey{}).(*userInfo)
+	return user
+}
+
+// bearerToken extracts the bearer token of a request from the Authorization header,
+// a WebSocket subprotocol or the access_token query parameter
+func bearerToken(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); header != "" {
+		if scheme, token, found := strings.Cut(header, " "); found && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+	}
+
+	for _, protocol := range websocketSubprotocols(r) {
+		if encoded := strings.TrimPrefix(protocol, bearerSubprotocolPrefix); encoded != protocol {
+			if token, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(encoded, "=")); err == nil {
+				return string(token)
+			}
+		}
+	}
+
+	return r.URL.Query().Get("access_token")
+}
+
+// websocketSubprotocols returns the subprotocols requested by a WebSocket client
+func websocketSubprotocols(r *http.Request) []string {
+	var protocols []string
+	for _, header := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, protocol := range strings.Split(header, ",") {
+			if protocol = strings.TrimSpace(protocol); protocol != "" {
+				protocols = append(protocols, protocol)
+			}
+		}
+	}
+	return protocols
+}
+
+// tokenCacheKey returns the cache key of a token, so tokens are not kept in memory
+func tokenCacheKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// tokenReviewAuthenticator validates tokens with the Kubernetes TokenReview API
+type tokenReviewAuthenticator struct {
+	client    kubernetes.Interface // Client of the cluster reviewing tokens
+	audiences []string             // Audiences the token must be valid for
+	cache     *ttlCache[*userInfo] // Recent review results, nil user for rejected tokens
+}
+
+// authenticate returns the identity of a token, reviewing it when not cached
+func (a *tokenReviewAuthenticator) authenticate(ctx context.Context, token string) (*userInfo, error) {
+	key := tokenCacheKey(token)
+	if user, ok := a.cache.get(key); ok {
+		if user == nil {
+			return nil, errUnauthenticated
+		}
+		return user, nil
+	}
+
+	review, err := a.client.AuthenticationV1().TokenReviews().Create(ctx, &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token, Audiences: a.audiences},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		// Review failures are not cached so that the next request retries
+		return nil, fmt.Errorf("token review failed: %w", err)
+	}
+
+	if !review.Status.Authenticated {
+		a.cache.set(key, nil)
+		return nil, errUnauthenticated
+	}
+	user := &userInfo{
+		Username: review.Status.User.Username,
+		UID:      review.Status.User.UID,
+		Groups:   review.Status.User.Groups,
+	}
+	a.cache.set(key, user)
+	return user, nil
+}
+
+// newAuthenticator creates the authenticator of the configured mode, nil when authentication is disabled
+func newAuthenticator(cfg *Config) (tokenAuthenticator, error) {
+	switch cfg.Auth.Mode {
+	case authModeNone:
+		return nil, nil
+	case authModeTokenReview:
+		config, err := newRESTConfig(cfg, false)
+		if err != nil {
+			return nil, err
+		}
+		client, err := kubernetes.NewForConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		return &tokenReviewAuthenticator{
+			client:    client,
+			audiences: cfg.Auth.Audiences,
+			cache:     newTTLCache[*userInfo](cfg.Auth.CacheTTL.Duration),
+		}, nil
+	case authModeOIDC:
+		return newOIDCAuthenticator(cfg.Auth.OIDC), nil
+	}
+	return nil, fmt.Errorf("unknown authentication mode %q", cfg.Auth.Mode)
+}
+
+// Endpoints served without authentication so that the kubelet can probe them
+var unauthenticatedPaths = map[string]bool{
+	"/healthz": true,
+	"/readyz":  true,
+}
+
+// requireAuthentication rejects requests without a valid bearer token or client
+// certificate and attaches the authenticated user to the request context of the
+// others. Bearer tokens take precedence; auth is nil when only certificates are accepted.
+func requireAuthentication(auth tokenAuthenticator, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if unauthenticatedPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token := bearerToken(r)
+		if token == "" || auth == nil {
+			if user := certificateUser(r); user != nil {
+				next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userContextKey{}, user)))
+				return
+			}
+		}
+		if token == "" || auth == nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		user, err := auth.authenticate(r.Context(), token)
+		if errors.Is(err, errUnauthenticated) {
+			log.WithFields(logrus.Fields{
+				"path":   r.URL.Path,
+				"remote": r.RemoteAddr,
+			}).Warning("Rejected unauthenticated request")
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			log.WithField("error", err).Error("Failed to authenticate request")
+			http.Error(w, "Authentication unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userContextKey{}, user)))
+	})
+}
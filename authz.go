@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"  // Request contexts
+	"fmt"      // Error formatting
+	"net/http" // HTTP server functionalities
+	"path"     // Matching namespace patterns
+	"sort"     // Sorting groups for cache keys
+	"strings"  // String building
+	"sync"     // Mutual exclusion
+	"time"     // For time-related operations
+
+	"github.com/sirupsen/logrus"                  // Package for structured logging
+	authorizationv1 "k8s.io/api/authorization/v1" // SubjectAccessReview API
+	v1 "k8s.io/api/core/v1"                       // Core v1 API for Kubernetes
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1" // Meta v1 API for Kubernetes
+	"k8s.io/apimachinery/pkg/fields"              // For selecting Kubernetes fields
+	"k8s.io/client-go/kubernetes"                 // Kubernetes client
+	"k8s.io/client-go/tools/cache"                // For caching Kubernetes objects
+)
+
+// Authorization modes
+const (
+	authzModeNone                = ""                    // Every authenticated user sees every event
+	authzModeSubjectAccessReview = "subjectaccessreview" // Users see the namespaces where they may list events
+	authzModeGroups              = "groups"              // Users see the namespaces mapped to their groups
+)
+
+// namespaceAuthorizer decides which namespaces a user may receive events from
+type namespaceAuthorizer interface {
+	authorize(ctx context.Context, user *userInfo) (*namespaceAccess, error)
+}
+
+// namespaceAccess is the set of namespaces a client may receive events from
+type namespaceAccess struct {
+	mu         sync.RWMutex    // Guards the fields below
+	all        bool            // Allowed in every namespace
+	namespaces map[string]bool // Allowed namespaces when not all
+	patterns   []string        // Allowed namespace patterns when not all
+}
+
+// allows reports whether events of a namespace may be sent to the client
+func (a *namespaceAccess) allows(namespace string) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.all || a.namespaces[namespace] {
+		return true
+	}
+	for _, pattern := range a.patterns {
+		if matched, _ := path.Match(pattern, namespace); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// empty reports whether the client may not receive any event
+func (a *namespaceAccess) empty() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return !a.all && len(a.namespaces) == 0 && len(a.patterns) == 0
+}
+
+// update replaces the allowed namespaces
+func (a *namespaceAccess) update(all bool, namespaces map[string]bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.all, a.namespaces = all, namespaces
+}
+
+// sarAuthorizer decides which namespaces a user may receive events from by asking
+// the Kubernetes SubjectAccessReview API whether the user may list events there
+type sarAuthorizer struct {
+	client     kubernetes.Interface // Client of the cluster reviewing access
+	ttl        time.Duration        // How long decisions are cached and access refreshed
+	decisions  *ttlCache[bool]      // Recent review decisions by user and namespace
+	namespaces cache.SharedInformer // Namespaces of the cluster
+
+	mu      sync.Mutex    // Guards changed
+	changed chan struct{} // Closed when a namespace is created
+}
+
+// newSARAuthorizer creates an authorizer reviewing access on the watched cluster and
+// starts watching its namespaces until stop is closed. The configuration only
+// allows it with a single cluster.
+func newSARAuthorizer(cfg *Config, stop <-chan struct{}) (*sarAuthorizer, error) {
+	config, err := (&clusterWatcher{cluster: resolveClusters(cfg)[0]}).restConfig()
+	if err != nil {
+		return nil, err
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	authz := &sarAuthorizer{
+		client:    client,
+		ttl:       cfg.Authorization.CacheTTL.Duration,
+		decisions: newTTLCache[bool](cfg.Authorization.CacheTTL.Duration),
+		changed:   make(chan struct{}),
+	}
+	authz.namespaces = cache.NewSharedInformer(
+		cache.NewListWatchFromClient(client.CoreV1().RESTClient(), "namespaces", metav1.NamespaceAll, fields.Everything()),
+		&v1.Namespace{}, // Watching Kubernetes Namespace objects
+		0,               // No resync period
+	)
+	authz.namespaces.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			authz.notifyChanged()
+		},
+	})
+	go authz.namespaces.Run(stop)
+	return authz, nil
+}
+
+// notifyChanged wakes up every client waiting for namespace changes
+func (a *sarAuthorizer) notifyChanged() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	close(a.changed)
+	a.changed = make(chan struct{})
+}
+
+// namespacesChanged returns a channel closed the next time a namespace is created
+func (a *sarAuthorizer) namespacesChanged() <-chan struct{} {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.changed
+}
+
+// allowed reviews whether user may list events in namespace, empty for every namespace
+func (a *sarAuthorizer) allowed(ctx context.Context, user *userInfo, namespace string) (bool, error) {
+	groups := append([]string(nil), user.Groups...)
+	sort.Strings(groups)
+	key := strings.Join(append([]string{user.Username, namespace}, groups...), "\x00")
+	if allowed, ok := a.decisions.get(key); ok {
+		return allowed, nil
+	}
+
+	review, err := a.client.AuthorizationV1().SubjectAccessReviews().Create(ctx, &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   user.Username,
+			UID:    user.UID,
+			Groups: user.Groups,
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace: namespace,
+				Verb:      "list",
+				Resource:  "events",
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return false, fmt.Errorf("subject access review failed: %w", err)
+	}
+
+	a.decisions.set(key, review.Status.Allowed)
+	return review.Status.Allowed, nil
+}
+
+// evaluate computes the namespaces user may receive events from
+func (a *sarAuthorizer) evaluate(ctx context.Context, user *userInfo) (bool, map[string]bool, error) {
+	// A user allowed cluster-wide needs no per-namespace reviews
+	all, err := a.allowed(ctx, user, metav1.NamespaceAll)
+	if err != nil || all {
+		return all, nil, err
+	}
+
+	if !cache.WaitForCacheSync(ctx.Done(), a.namespaces.HasSynced) {
+		return false, nil, ctx.Err()
+	}
+	namespaces := map[string]bool{}
+	for _, obj := range a.namespaces.GetStore().List() {
+		namespace, ok := obj.(*v1.Namespace)
+		if !ok {
+			continue
+		}
+		allowed, err := a.allowed(ctx, user, namespace.Name)
+		if err != nil {
+			return false, nil, err
+		}
+		if allowed {
+			namespaces[namespace.Name] = true
+		}
+	}
+	return false, namespaces, nil
+}
+
+// authorize computes the access of user and keeps it up to date until ctx is done,
+// re-evaluating it when cached decisions expire and when namespaces are created
+func (a *sarAuthorizer) authorize(ctx context.Context, user *userInfo) (*namespaceAccess, error) {
+	all, namespaces, err := a.evaluate(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	access := &namespaceAccess{all: all, namespaces: namespaces}
+	log.WithFields(user.fields()).WithFields(logrus.Fields{
+		"allNamespaces": all,
+		"namespaces":    len(namespaces),
+	}).Info("Authorized namespace access")
+
+	go func() {
+		ticker := time.NewTicker(a.ttl)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-a.namespacesChanged():
+			}
+
+			all, namespaces, err := a.evaluate(ctx, user)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.WithFields(user.fields()).WithField("error", err).Warning("Failed to refresh namespace access, keeping previous decision")
+				}
+				continue
+			}
+			access.update(all, namespaces)
+		}
+	}()
+	return access, nil
+}
+
+// groupAuthorizer grants the namespaces mapped to a user's groups by the configuration
+type groupAuthorizer struct {
+	groupNamespaces map[string][]string // Namespace patterns allowed to each group
+}
+
+// authorize returns the namespaces mapped to the groups of user, "*" granting every namespace
+func (a *groupAuthorizer) authorize(ctx context.Context, user *userInfo) (*namespaceAccess, error) {
+	access := &namespaceAccess{namespaces: map[string]bool{}}
+	for _, group := range user.Groups {
+		for _, pattern := range a.groupNamespaces[group] {
+			switch {
+			case pattern == "*":
+				access.all = true
+			case strings.ContainsAny(pattern, "*?["):
+				access.patterns = append(access.patterns, pattern)
+			default:
+				access.namespaces[pattern] = true
+			}
+		}
+	}
+	log.WithFields(user.fields()).WithFields(logrus.Fields{
+		"allNamespaces": access.all,
+		"namespaces":    len(access.namespaces),
+		"patterns":      access.patterns,
+	}).Info("Authorized namespace access")
+	return access, nil
+}
+
+// newAuthorizer creates the authorizer of the configured mode, nil when authorization is disabled
+func newAuthorizer(cfg *Config, stop <-chan struct{}) (namespaceAuthorizer, error) {
+	switch cfg.Authorization.Mode {
+	case authzModeNone:
+		return nil, nil
+	case authzModeSubjectAccessReview:
+		authz, err := newSARAuthorizer(cfg, stop)
+		if err != nil {
+			return nil, err
+		}
+		return authz, nil
+	case authzModeGroups:
+		return &groupAuthorizer{groupNamespaces: cfg.Authorization.GroupNamespaces}, nil
+	}
+	return nil, fmt.Errorf("unknown authorization mode %q", cfg.Authorization.Mode)
+}
+
+// authorizeRequest attaches the namespace access of the request's user to sub,
+// answering the request itself and returning false when the user is not allowed.
+// The access is kept up to date until ctx is done.
+func authorizeRequest(ctx context.Context, w http.ResponseWriter, r *http.Request, authz namespaceAuthorizer, sub *subscription) bool {
+	user := requestUser(r)
+	if authz == nil || user == nil {
+		return true
+	}
+
+	access, err := authz.authorize(ctx, user)
+	if err != nil {
+		log.WithFields(user.fields()).WithField("error", err).Error("Failed to authorize request")
+		http.Error(w, "Authorization unavailable", http.StatusServiceUnavailable)
+		return false
+	}
+	if access.empty() {
+		log.WithFields(user.fields()).Warning("User may not list events in any namespace")
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return false
+	}
+	sub.Access = access
+	return true
+}
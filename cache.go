@@ -0,0 +1,51 @@
+package main
+
+import (
+	"sync" // Mutual exclusion
+	"time" // For time-related operations
+)
+
+// ttlEntry is a cached value with its expiry
+type ttlEntry[V any] struct {
+	value   V         // Cached value
+	expires time.Time // Time the value stops being valid
+}
+
+// ttlCache is a small map whose entries expire after a fixed duration
+type ttlCache[V any] struct {
+	mu      sync.Mutex             // Guards entries
+	ttl     time.Duration          // Lifetime of an entry
+	entries map[string]ttlEntry[V] // Cached values by key
+}
+
+// newTTLCache creates a cache keeping entries for ttl
+func newTTLCache[V any](ttl time.Duration) *ttlCache[V] {
+	return &ttlCache[V]{ttl: ttl, entries: map[string]ttlEntry[V]{}}
+}
+
+// get returns the value cached for key, if any and not expired
+func (c *ttlCache[V]) get(key string) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || time.Now().After(entry.expires) {
+		var zero V
+		return zero, false
+	}
+	return entry.value, true
+}
+
+// set caches value for key, dropping expired entries
+func (c *ttlCache[V]) set(key string, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for k, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = ttlEntry[V]{value: value, expires: now.Add(c.ttl)}
+}
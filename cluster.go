@@ -0,0 +1,562 @@
+package main
+
+import (
+	"context" // Request contexts for the API
+	"fmt"     // Error formatting
+	"strings" // String manipulation
+	"sync"    // Mutual exclusion
+	"time"    // For time-related operations
+
+	"github.com/sirupsen/logrus"                  // Package for structured logging
+	v1 "k8s.io/api/core/v1"                       // Core v1 API for Kubernetes
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1" // Meta v1 API for Kubernetes
+	"k8s.io/apimachinery/pkg/runtime"             // Kubernetes object interfaces
+	"k8s.io/apimachinery/pkg/types"               // Object UIDs
+	"k8s.io/apimachinery/pkg/watch"               // Watch event types
+	"k8s.io/client-go/dynamic"                    // Client for resources of any type
+	"k8s.io/client-go/informers"                  // Informer factories shared by the trackers
+	"k8s.io/client-go/kubernetes"                 // Kubernetes client
+	"k8s.io/client-go/rest"                       // RESTful implementation of Kubernetes API
+	"k8s.io/client-go/tools/cache"                // For caching Kubernetes objects
+	"k8s.io/client-go/tools/clientcmd"            // For command line configuration of Kubernetes
+)
+
+// Resync check period informers are created with. The first handler asking for a
+// shorter resync lowers it, while handlers asking for none are never resynced.
+const informerResyncCheckPeriod = 12 * time.Hour
+
+// Bounds of the delay between attempts to connect to a cluster
+const (
+	clusterRetryMin = 5 * time.Second
+	clusterRetryMax = 2 * time.Minute
+)
+
+// eventHandler receives every change to a Kubernetes event seen in a cluster
+type eventHandler func(cluster string, eventType watch.EventType, event *v1.Event)
+
+// clusterHealth is the connection state of a cluster
+type clusterHealth struct {
+	Name           string          `json:"name"`           // Cluster name
+	Reachable      bool            `json:"reachable"`      // Last list or watch call succeeded
+	Synced         bool            `json:"synced"`         // Initial listing of every informer completed
+	FailingSince   time.Time       `json:"failingSince"`   // Start of the current run of failures (zero when reachable)
+	LastError      string          `json:"lastError"`      // Last connection or watch error
+	LastErrorAt    time.Time       `json:"lastErrorAt"`    // Time of the last error
+	LastEventAt    time.Time       `json:"lastEventAt"`    // Time the last event was received
+	ConnectRetries int             `json:"connectRetries"` // Failed attempts to create the client
+	Watchers       []watcherStatus `json:"watchers"`       // State of every informer
+}
+
+// watcherStatus is the state of the informer of one resource in one namespace
+type watcherStatus struct {
+	Resource        string    `json:"resource"`        // Watched resource
+	Namespace       string    `json:"namespace"`       // Watched namespace, empty for all
+	Synced          bool      `json:"synced"`          // Initial listing completed
+	LastEventAt     time.Time `json:"lastEventAt"`     // Time the last event was received
+	ResourceVersion string    `json:"resourceVersion"` // Last resourceVersion seen by the informer
+	Restarts        int       `json:"restarts"`        // Times the watch was re-established
+	Failures        int       `json:"failures"`        // Failed list or watch calls
+}
+
+// namespaceWatch is the informer of one resource in one namespace
+type namespaceWatch struct {
+	resource    string                    // Watched resource
+	namespace   string                    // Watched namespace, empty for all
+	informer    cache.SharedIndexInformer // Running informer
+	watches     int                       // Watch calls made so far
+	failures    int                       // Failed list or watch calls
+	lastEventAt time.Time                 // Time the last event was received
+	diffed      bool                      // Diff handler added
+}
+
+// objectTracker watches objects of a cluster other than events and reports their
+// changes as synthetic events, which go through the same handlers as real ones
+type objectTracker interface {
+	// start starts the informers of the tracker and returns their sync functions
+	start(w *clusterWatcher, clientset kubernetes.Interface, stop <-chan struct{}) []cache.InformerSynced
+}
+
+// Trackers that can be enabled with the watchers setting, by name
+var objectTrackers = map[string]func() objectTracker{
+	"pods":        newPodTracker,
+	"nodes":       newNodeTracker,
+	"deployments": newRolloutTracker,
+	"jobs":        newJobTracker,
+	"hpas":        newHPATracker,
+	"volumes":     newVolumeTracker,
+	"configs":     newConfigTracker,
+	"services":    newServiceTracker,
+}
+
+// clusterWatcher watches the events of one cluster, reconnecting on its own so
+// that an unreachable cluster does not affect the others
+type clusterWatcher struct {
+	name       string         // Cluster name attached to every event
+	cluster    ClusterConfig  // How to reach the cluster
+	namespaces []string       // Namespaces to watch
+	trackers   []string       // Enabled object trackers
+	handlers   []eventHandler // Receivers of event changes
+
+	// Informer factories by namespace, sharing informers between the trackers
+	factories map[string]informers.SharedInformerFactory
+
+	mu        sync.Mutex           // Guards the fields below
+	health    clusterHealth        // Connection state
+	informers []*namespaceWatch    // Running informers
+	diffed    map[types.UID]string // Last diffed resource version of every updated object
+}
+
+// resolveClusters returns the clusters to watch, defaulting to the single cluster
+// given by the top-level kubeconfig and context settings. Clusters without a
+// kubeconfig of their own use the top-level one.
+func resolveClusters(cfg *Config) []ClusterConfig {
+	if len(cfg.Clusters) == 0 {
+		return []ClusterConfig{{Kubeconfig: cfg.Kubeconfig, Context: cfg.Context}}
+	}
+	clusters := append([]ClusterConfig(nil), cfg.Clusters...)
+	for i := range clusters {
+		if clusters[i].Kubeconfig == "" {
+			clusters[i].Kubeconfig = cfg.Kubeconfig
+		}
+	}
+	return clusters
+}
+
+// clusterName returns the name events of a cluster are tagged with
+func clusterName(cluster ClusterConfig) string {
+	switch {
+	case cluster.Name != "":
+		return cluster.Name
+	case cluster.Context != "":
+		return cluster.Context
+	case cluster.Secret != "":
+		return cluster.Secret
+	}
+	return "default"
+}
+
+// newClusterWatchers creates a watcher for every configured cluster
+func newClusterWatchers(cfg *Config, handlers ...eventHandler) []*clusterWatcher {
+	var watchers []*clusterWatcher
+	for _, cluster := range resolveClusters(cfg) {
+		watchers = append(watchers, &clusterWatcher{
+			name:       clusterName(cluster),
+			cluster:    cluster,
+			namespaces: watchedNamespaces(cfg),
+			trackers:   cfg.Watchers,
+			handlers:   handlers,
+			diffed:     map[types.UID]string{},
+		})
+	}
+	return watchers
+}
+
+// restConfig builds the client configuration of the cluster
+func (w *clusterWatcher) restConfig() (*rest.Config, error) {
+	if w.cluster.Secret == "" {
+		return newRESTConfig(&Config{
+			Kubeconfig: w.cluster.Kubeconfig,
+			Context:    w.cluster.Context,
+			Client:     cfg.Client,
+		}, w.cluster.InCluster)
+	}
+
+	// Remote cluster whose kubeconfig is stored in a Secret of the local cluster
+	namespace, name, found := strings.Cut(w.cluster.Secret, "/")
+	if !found {
+		return nil, fmt.Errorf("secret %q must be given as namespace/name", w.cluster.Secret)
+	}
+	localConfig, err := newRESTConfig(&cfg, false)
+	if err != nil {
+		return nil, err
+	}
+	local, err := kubernetes.NewForConfig(localConfig)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := local.CoreV1().Secrets(namespace).Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	key := w.cluster.SecretKey
+	if key == "" {
+		key = "kubeconfig"
+	}
+	data, ok := secret.Data[key]
+	if !ok {
+		return nil, fmt.Errorf("secret %s has no key %q", w.cluster.Secret, key)
+	}
+
+	clientConfig, err := clientcmd.NewClientConfigFromBytes(data)
+	if err != nil {
+		return nil, err
+	}
+	config, err := clientConfig.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	config.QPS = cfg.Client.QPS
+	config.Burst = cfg.Client.Burst
+	return config, nil
+}
+
+// run connects to the cluster and watches its events until stop is closed,
+// retrying with backoff while the cluster cannot be reached
+func (w *clusterWatcher) run(stop <-chan struct{}) {
+	logger := log.WithField("cluster", w.name)
+	delay := clusterRetryMin
+
+	for {
+		config, clientset, err := w.connect()
+		if err == nil {
+			w.watch(config, clientset, stop)
+			return
+		}
+
+		w.recordError(err)
+		w.mu.Lock()
+		w.health.ConnectRetries++
+		w.mu.Unlock()
+		logger.WithFields(logrus.Fields{
+			"error": err,
+			"retry": delay.String(),
+		}).Warning("Failed to connect to cluster")
+
+		select {
+		case <-time.After(delay):
+		case <-stop:
+			return
+		}
+		if delay *= 2; delay > clusterRetryMax {
+			delay = clusterRetryMax
+		}
+	}
+}
+
+// watches reports whether objects of namespace are watched, always true for
+// cluster-scoped objects
+func (w *clusterWatcher) watches(namespace string) bool {
+	if namespace == "" {
+		return true
+	}
+	for _, watched := range w.namespaces {
+		if watched == metav1.NamespaceAll || watched == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// connect creates the client of the cluster
+func (w *clusterWatcher) connect() (*rest.Config, *kubernetes.Clientset, error) {
+	config, err := w.restConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	return config, clientset, err
+}
+
+// watch starts an event informer for every namespace, then the enabled object
+// trackers and the configured resource watchers, and blocks until stop is closed.
+// Informers relist and rewatch with their own backoff when the cluster goes away.
+func (w *clusterWatcher) watch(config *rest.Config, clientset *kubernetes.Clientset, stop <-chan struct{}) {
+	logger := log.WithField("cluster", w.name)
+
+	w.factories = map[string]informers.SharedInformerFactory{}
+	for _, namespace := range append([]string{metav1.NamespaceAll}, w.namespaces...) {
+		w.factories[namespace] = informers.NewSharedInformerFactoryWithOptions(clientset, 0, informers.WithNamespace(namespace))
+	}
+
+	var synced []cache.InformerSynced
+	for _, namespace := range w.namespaces {
+		// The handlers record their events in the watch, which must exist before the informer runs
+		nw := w.newInformer("events", namespace, newEventListWatch(clientset, namespace), &v1.Event{})
+		w.addHandler(nw, cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				w.dispatch(nw, watch.Added, obj)
+			},
+			UpdateFunc: func(_, obj interface{}) {
+				w.dispatch(nw, watch.Modified, obj)
+			},
+			DeleteFunc: func(obj interface{}) {
+				w.dispatch(nw, watch.Deleted, obj)
+			},
+		}, 0, false)
+		go nw.informer.Run(stop)
+		synced = append(synced, nw.informer.HasSynced)
+	}
+	for _, name := range w.trackers {
+		synced = append(synced, objectTrackers[name]().start(w, clientset, stop)...)
+	}
+	if len(cfg.Resources) > 0 {
+		if dynamicClient, err := dynamic.NewForConfig(config); err != nil {
+			logger.WithField("error", err).Error("Failed to create dynamic client, not watching configured resources")
+		} else {
+			for _, resource := range cfg.Resources {
+				// Rules were validated with the configuration
+				tracker, _ := newResourceTracker(resource, dynamicClient)
+				synced = append(synced, tracker.start(w, clientset, stop)...)
+			}
+		}
+	}
+
+	if alerting != nil && alerting.watchesReadiness() {
+		synced = append(synced, (&alertReadinessTracker{engine: alerting}).start(w, clientset, stop)...)
+	}
+
+	for _, factory := range w.factories {
+		factory.Start(stop)
+	}
+	logger.WithField("trackers", w.trackers).Info("Watching cluster events")
+
+	if cache.WaitForCacheSync(stop, synced...) {
+		w.mu.Lock()
+		w.health.Synced = true
+		w.mu.Unlock()
+		logger.Info("Cluster events synced")
+	}
+	<-stop
+}
+
+// sharedInformer returns the informer of resource in namespace shared by every
+// tracker of the cluster, adding handler to it; see addHandler. Informers are
+// shared by object type through the factory of the namespace, so lw must list
+// every object of the namespace. They run once every tracker has started.
+func (w *clusterWatcher) sharedInformer(resource, namespace string, lw *cache.ListWatch, objType runtime.Object, resync time.Duration, handler cache.ResourceEventHandler, diffs bool) *namespaceWatch {
+	informer := w.factories[namespace].InformerFor(objType, func(kubernetes.Interface, time.Duration) cache.SharedIndexInformer {
+		return w.newInformer(resource, namespace, lw, objType).informer
+	})
+
+	var nw *namespaceWatch
+	w.mu.Lock()
+	for _, candidate := range w.informers {
+		if candidate.informer == informer {
+			nw = candidate
+		}
+	}
+	w.mu.Unlock()
+
+	w.addHandler(nw, handler, resync, diffs)
+	return nw
+}
+
+// startInformer runs an informer of resource in namespace used by the caller only,
+// adding handler to it, until stop is closed; see addHandler
+func (w *clusterWatcher) startInformer(resource, namespace string, lw *cache.ListWatch, objType runtime.Object, resync time.Duration, handler cache.ResourceEventHandler, diffs bool, stop <-chan struct{}) *namespaceWatch {
+	nw := w.newInformer(resource, namespace, lw, objType)
+	w.addHandler(nw, handler, resync, diffs)
+	go nw.informer.Run(stop)
+	return nw
+}
+
+// addHandler adds handler to an informer, resynced every resync when not zero.
+// Updates are diffed when diffs is set, for the objects a tracker reports on;
+// trackers that only look up related objects leave it unset.
+func (w *clusterWatcher) addHandler(nw *namespaceWatch, handler cache.ResourceEventHandler, resync time.Duration, diffs bool) {
+	if handler != nil {
+		nw.informer.AddEventHandlerWithResyncPeriod(handler, resync)
+	}
+	w.mu.Lock()
+	diff := diffs && cfg.Diffs.Enabled && !nw.diffed
+	nw.diffed = nw.diffed || diff
+	w.mu.Unlock()
+	if diff {
+		nw.informer.AddEventHandlerWithResyncPeriod(w.diffHandler(), 0)
+	}
+}
+
+// newInformer creates an informer of resource in namespace, tracking its list and
+// watch calls in the cluster health. It is up to the caller to run it.
+func (w *clusterWatcher) newInformer(resource, namespace string, lw *cache.ListWatch, objType runtime.Object) *namespaceWatch {
+	nw := &namespaceWatch{resource: resource, namespace: namespace}
+	nw.informer = cache.NewSharedIndexInformer(w.observedListWatch(nw, lw), objType, informerResyncCheckPeriod,
+		cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	nw.informer.SetWatchErrorHandler(func(_ *cache.Reflector, err error) {
+		log.WithFields(logrus.Fields{
+			"cluster":   w.name,
+			"resource":  resource,
+			"namespace": namespace,
+			"error":     err,
+		}).Warning("Watch failed, retrying")
+	})
+
+	w.mu.Lock()
+	w.informers = append(w.informers, nw)
+	w.mu.Unlock()
+	return nw
+}
+
+// namespaceObjects returns the objects of a namespace cached by the given watches
+func namespaceObjects(watches []*namespaceWatch, namespace string) []interface{} {
+	var objects []interface{}
+	for _, nw := range watches {
+		items, err := nw.informer.GetIndexer().ByIndex(cache.NamespaceIndex, namespace)
+		if err != nil {
+			continue
+		}
+		objects = append(objects, items...)
+	}
+	return objects
+}
+
+// observedListWatch wraps a ListWatch to track the outcome of every list and watch call
+func (w *clusterWatcher) observedListWatch(nw *namespaceWatch, lw *cache.ListWatch) *cache.ListWatch {
+	list, watchFunc := lw.ListFunc, lw.WatchFunc
+	lw.ListFunc = func(options metav1.ListOptions) (runtime.Object, error) {
+		obj, err := list(options)
+		w.observe(nw, false, err)
+		return obj, err
+	}
+	lw.WatchFunc = func(options metav1.ListOptions) (watch.Interface, error) {
+		watcher, err := watchFunc(options)
+		w.observe(nw, true, err)
+		return watcher, err
+	}
+	return lw
+}
+
+// observe records the outcome of a list or watch call
+func (w *clusterWatcher) observe(nw *namespaceWatch, isWatch bool, err error) {
+	if err != nil {
+		w.recordError(err)
+		w.mu.Lock()
+		nw.failures++
+		w.mu.Unlock()
+		return
+	}
+
+	w.mu.Lock()
+	if isWatch {
+		nw.watches++
+	}
+	recovered := !w.health.Reachable && !w.health.FailingSince.IsZero()
+	w.health.Reachable = true
+	w.health.FailingSince = time.Time{}
+	w.mu.Unlock()
+	if recovered {
+		log.WithField("cluster", w.name).Info("Cluster reachable again")
+	}
+}
+
+// dispatch passes an informer notification to the handlers
+func (w *clusterWatcher) dispatch(nw *namespaceWatch, eventType watch.EventType, obj interface{}) {
+	// Deleted objects may be wrapped when the final state is unknown
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	event, ok := obj.(*v1.Event) // Casting to *v1.Event
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	w.mu.Lock()
+	w.health.LastEventAt = now
+	nw.lastEventAt = now
+	w.mu.Unlock()
+
+	for _, handler := range w.handlers {
+		handler(w.name, eventType, event)
+	}
+}
+
+// Component reported as the source of synthetic events
+const syntheticSource = "k8s-translator"
+
+// syntheticEvent builds the event reporting a change of obj noticed by a tracker.
+// severity is v1.EventTypeNormal or v1.EventTypeWarning.
+func syntheticEvent(kind string, obj metav1.Object, reason, severity, message string) *v1.Event {
+	now := metav1.Now()
+	return &v1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s.%x", obj.GetName(), now.UnixNano()),
+			Namespace: obj.GetNamespace(),
+		},
+		InvolvedObject: v1.ObjectReference{
+			Kind:            kind,
+			Name:            obj.GetName(),
+			Namespace:       obj.GetNamespace(),
+			UID:             obj.GetUID(),
+			ResourceVersion: obj.GetResourceVersion(),
+		},
+		Reason:         reason,
+		Message:        message,
+		Type:           severity,
+		Source:         v1.EventSource{Component: syntheticSource},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+}
+
+// emit passes a synthetic event produced by an object tracker to the handlers
+func (w *clusterWatcher) emit(event *v1.Event) {
+	w.emitAs(watch.Added, event)
+}
+
+// emitAs passes a synthetic event to the handlers as an event of the given type
+func (w *clusterWatcher) emitAs(eventType watch.EventType, event *v1.Event) {
+	for _, handler := range w.handlers {
+		handler(w.name, eventType, event)
+	}
+}
+
+// recordError stores the last error seen for the cluster
+func (w *clusterWatcher) recordError(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	now := time.Now()
+	if w.health.FailingSince.IsZero() {
+		w.health.FailingSince = now
+	}
+	w.health.Reachable = false
+	w.health.LastError = err.Error()
+	w.health.LastErrorAt = now
+}
+
+// status returns the current connection state of the cluster
+func (w *clusterWatcher) status() clusterHealth {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	health := w.health
+	health.Name = w.name
+	health.Watchers = nil
+	for _, nw := range w.informers {
+		restarts := nw.watches - 1
+		if restarts < 0 {
+			restarts = 0
+		}
+		health.Watchers = append(health.Watchers, watcherStatus{
+			Resource:        nw.resource,
+			Namespace:       nw.namespace,
+			Synced:          nw.informer.HasSynced(),
+			LastEventAt:     nw.lastEventAt,
+			ResourceVersion: nw.informer.LastSyncResourceVersion(),
+			Restarts:        restarts,
+			Failures:        nw.failures,
+		})
+	}
+	return health
+}
+
+// snapshot returns the events currently cached by the informers of the cluster
+func (w *clusterWatcher) snapshot() []*v1.Event {
+	w.mu.Lock()
+	informers := append([]*namespaceWatch(nil), w.informers...)
+	w.mu.Unlock()
+
+	var events []*v1.Event
+	for _, nw := range informers {
+		if nw.resource != "events" {
+			continue
+		}
+		for _, obj := range nw.informer.GetStore().List() {
+			if event, ok := obj.(*v1.Event); ok {
+				events = append(events, event)
+			}
+		}
+	}
+	return events
+}
@@ -0,0 +1,814 @@
+package main
+
+import (
+	"flag"    // Command line flag parsing
+	"fmt"     // Error formatting
+	"io"      // Output streams
+	"os"      // Environment and file access
+	"path"    // Matching namespace patterns
+	"sort"    // Sorting option names
+	"strconv" // Parsing numbers
+	"strings" // String manipulation
+	"time"    // For time-related operations
+
+	"github.com/sirupsen/logrus"                  // Package for structured logging
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1" // Meta v1 API for Kubernetes
+	"sigs.k8s.io/yaml"                            // YAML configuration files
+)
+
+// Outputs the translator can send events to
+const (
+	outputWebSocket = "websocket" // Serve events on /ws
+	outputLog       = "log"       // Log every streamed event
+)
+
+// Environment variable naming the configuration file
+const configFileEnv = "TRANSLATOR_CONFIG"
+
+// Config is the effective configuration of the translator. Values are resolved
+// with the following precedence, highest first:
+//
+//  1. command line flags
+//  2. environment variables (TRANSLATOR_*, plus the standard KUBECONFIG)
+//  3. the YAML configuration file given by --config or TRANSLATOR_CONFIG
+//  4. built-in defaults
+type Config struct {
+	ListenAddress string                  `json:"listenAddress"` // Address the HTTP server listens on
+	TLS           TLSConfig               `json:"tls"`           // TLS serving settings
+	Kubeconfig    string                  `json:"kubeconfig"`    // Path(s) to kubeconfig files, empty for default loading rules
+	Context       string                  `json:"context"`       // Kubeconfig context, empty for the current context
+	Clusters      []ClusterConfig         `json:"clusters"`      // Clusters to watch, empty for the one given by kubeconfig and context
+	Namespaces    []string                `json:"namespaces"`    // Namespaces to watch, empty for all
+	Watchers      []string                `json:"watchers"`      // Object trackers enabled besides events
+	Pods          PodWatcherConfig        `json:"pods"`          // Pod tracker settings
+	Jobs          JobWatcherConfig        `json:"jobs"`          // Job tracker settings
+	Volumes       VolumeWatcherConfig     `json:"volumes"`       // Volume tracker settings
+	Configs       ConfigWatcherConfig     `json:"configs"`       // ConfigMap and Secret tracker settings
+	Resources     []ResourceWatcherConfig `json:"resources"`     // Resources of any type watched through the dynamic client
+	Diffs         DiffConfig              `json:"diffs"`         // Field-level diffs of updated objects
+	Alerts        AlertConfig             `json:"alerts"`        // Alert rules
+	Silences      SilenceConfig           `json:"silences"`      // Silences and maintenance windows
+	Storms        StormConfig             `json:"storms"`        // Event storm detection
+	Anomalies     AnomalyConfig           `json:"anomalies"`     // Event rate anomaly detection
+	Log           LogConfig               `json:"log"`           // Logging settings
+	Client        ClientConfig            `json:"client"`        // Kubernetes client settings
+	WebSocket     WebSocketConfig         `json:"websocket"`     // WebSocket settings
+	Outputs       []string                `json:"outputs"`       // Enabled outputs
+	Health        HealthConfig            `json:"health"`        // Health check settings
+	Auth          AuthConfig              `json:"auth"`          // Client authentication settings
+	Authorization AuthzConfig             `json:"authorization"` // Client authorization settings
+	Redaction     RedactionConfig         `json:"redaction"`     // Sensitive data redaction settings
+	Replay        ReplayConfig            `json:"replay"`        // Replay mode settings
+	Record        RecordConfig            `json:"record"`        // Record mode settings
+}
+
+// ClusterConfig describes how to reach one of the watched clusters
+type ClusterConfig struct {
+	Name       string `json:"name"`       // Name events are tagged with, defaults to the context
+	Kubeconfig string `json:"kubeconfig"` // Path(s) to kubeconfig files, empty for the top-level kubeconfig setting
+	Context    string `json:"context"`    // Kubeconfig context
+	InCluster  bool   `json:"inCluster"`  // Use the in-cluster configuration of the pod
+	Secret     string `json:"secret"`     // namespace/name of a local Secret holding the kubeconfig of a remote cluster
+	SecretKey  string `json:"secretKey"`  // Key of the kubeconfig in the Secret, defaults to "kubeconfig"
+}
+
+// PodWatcherConfig configures the pod tracker
+type PodWatcherConfig struct {
+	InitTimeout   metav1.Duration `json:"initTimeout"`   // How long init containers may run before being reported as stuck
+	FlapThreshold int             `json:"flapThreshold"` // Readiness transitions within the window reported as flapping
+	FlapWindow    metav1.Duration `json:"flapWindow"`    // Window readiness transitions are counted in
+}
+
+// JobWatcherConfig configures the job tracker
+type JobWatcherConfig struct {
+	MissedScheduleGrace metav1.Duration `json:"missedScheduleGrace"` // How late a CronJob run may be before being reported as missed, unless the CronJob sets a starting deadline
+	SuspendedThreshold  metav1.Duration `json:"suspendedThreshold"`  // How long a CronJob may stay suspended before being reported
+}
+
+// VolumeWatcherConfig configures the volume tracker
+type VolumeWatcherConfig struct {
+	PendingThreshold metav1.Duration `json:"pendingThreshold"` // How long claims may stay pending before being reported
+}
+
+// ConfigWatcherConfig configures the ConfigMap and Secret tracker
+type ConfigWatcherConfig struct {
+	MaxValueLength    int      `json:"maxValueLength"`    // Characters of ConfigMap values shown in diffs, 0 for no limit
+	IgnoreSecretTypes []string `json:"ignoreSecretTypes"` // Secret types whose changes are not reported
+}
+
+// ResourceWatcherConfig describes a resource watched through the dynamic client,
+// such as a custom resource, and the fields whose changes are reported
+type ResourceWatcherConfig struct {
+	Group    string         `json:"group"`    // API group, empty for the core group
+	Version  string         `json:"version"`  // API version
+	Resource string         `json:"resource"` // Plural resource name
+	Kind     string         `json:"kind"`     // Kind named in events, discovered when empty
+	Rules    []ResourceRule `json:"rules"`    // Fields compared between versions of an object
+}
+
+// ResourceRule selects fields of a watched resource to diff
+type ResourceRule struct {
+	Name     string `json:"name"`     // Rule name used in messages
+	JSONPath string `json:"jsonPath"` // kubectl-style JSONPath selecting the fields
+	Reason   string `json:"reason"`   // Reason of the events, defaults to <Kind><Name>Changed
+	Warning  string `json:"warning"`  // Regular expression marking new values as warnings
+}
+
+// DiffConfig configures the field-level diffs reported when objects watched by
+// the trackers are updated. Paths are JSON pointers such as /spec/replicas, where
+// "*" matches one segment and "**" any number of them; a path covers its children.
+type DiffConfig struct {
+	Enabled       bool                      `json:"enabled"`       // Report diffs of updated objects
+	Ignore        []string                  `json:"ignore"`        // Paths ignored for every kind, besides the built-in ones
+	MaxOperations int                       `json:"maxOperations"` // Changes listed in event messages, 0 for all
+	Kinds         map[string]KindDiffConfig `json:"kinds"`         // Paths ignored or included by kind, such as Deployment
+}
+
+// KindDiffConfig selects the fields diffed for one kind
+type KindDiffConfig struct {
+	Ignore  []string `json:"ignore"`  // Paths ignored, "/" to disable diffs of the kind
+	Include []string `json:"include"` // Only paths diffed, empty for all
+}
+
+// AlertConfig configures the alert rules evaluated on the watched events
+type AlertConfig struct {
+	Rules              []AlertRule     `json:"rules"`              // Alert rules
+	EvaluationInterval metav1.Duration `json:"evaluationInterval"` // Interval at which pending and firing alerts are re-evaluated
+	ResolvedRetention  metav1.Duration `json:"resolvedRetention"`  // How long resolved alerts stay listed on /api/v1/alerts
+}
+
+// AlertRule raises an alert when more than Threshold matching events of one group
+// occur within Window
+type AlertRule struct {
+	Name      string          `json:"name"`      // Rule name
+	Severity  string          `json:"severity"`  // Alert severity (critical, warning, info), warning by default
+	Match     AlertMatch      `json:"match"`     // Events counted by the rule
+	GroupBy   string          `json:"groupBy"`   // Events counted together (cluster, namespace, workload, object), workload by default
+	Threshold int             `json:"threshold"` // Alert on more than this many events, 0 for any event
+	Window    metav1.Duration `json:"window"`    // Window the events are counted in, 10m by default
+	For       metav1.Duration `json:"for"`       // How long the threshold must stay crossed before firing
+	Resolve   AlertResolve    `json:"resolve"`   // Resolution condition of firing alerts
+}
+
+// AlertMatch selects events; empty fields match everything
+type AlertMatch struct {
+	Clusters   []string `json:"clusters"`   // Clusters of the events
+	Namespaces []string `json:"namespaces"` // Namespaces or namespace patterns, such as prod-*
+	Kinds      []string `json:"kinds"`      // Kinds of the involved objects
+	Reasons    []string `json:"reasons"`    // Event reasons
+	Severity   string   `json:"severity"`   // Normal or Warning
+	Message    string   `json:"message"`    // Regular expression matching the message
+}
+
+// AlertResolve describes when a firing alert is resolved; the first condition met wins
+type AlertResolve struct {
+	QuietFor metav1.Duration `json:"quietFor"` // Time without matching events, the window by default
+	Reasons  []string        `json:"reasons"`  // Reasons of events of the group resolving the alert, such as Started
+	PodReady bool            `json:"podReady"` // Resolve when a pod of the group becomes Ready
+}
+
+// SilenceConfig configures the silences suppressing alert notifications
+type SilenceConfig struct {
+	MaintenanceWindows []MaintenanceWindow `json:"maintenanceWindows"` // Recurring maintenance windows
+	ExpiredRetention   metav1.Duration     `json:"expiredRetention"`   // How long expired silences stay listed on /api/v1/silences
+}
+
+// MaintenanceWindow silences the matching alerts for Duration from every start of Schedule
+type MaintenanceWindow struct {
+	Name     string          `json:"name"`     // Window name
+	Schedule string          `json:"schedule"` // Cron expression of the starts, such as "0 2 * * 6"
+	TimeZone string          `json:"timeZone"` // Time zone of the schedule, local time when empty
+	Duration metav1.Duration `json:"duration"` // Length of every occurrence
+	Matcher  SilenceMatcher  `json:"matcher"`  // Alerts silenced
+	Comment  string          `json:"comment"`  // Why the alerts are silenced
+}
+
+// SilenceMatcher selects the alerts a silence suppresses. Empty fields match
+// everything, but at least one must be set.
+type SilenceMatcher struct {
+	Rules      []string `json:"rules,omitempty"`      // Alert rule names
+	Clusters   []string `json:"clusters,omitempty"`   // Clusters
+	Namespaces []string `json:"namespaces,omitempty"` // Namespaces or namespace patterns, such as prod-*
+	Kinds      []string `json:"kinds,omitempty"`      // Kinds of the objects of the alerting events, such as Node
+	Reasons    []string `json:"reasons,omitempty"`    // Reasons of the alerting events, such as NodeNotReady
+	Severities []string `json:"severities,omitempty"` // Alert severities
+}
+
+// StormConfig configures the detection of event storms: keys of namespace, workload
+// and reason whose events are rolled up while they exceed the threshold
+type StormConfig struct {
+	Threshold int             `json:"threshold"` // Events of one key within the window starting a storm, 0 to disable
+	Window    metav1.Duration `json:"window"`    // Window of the rates and interval of the roll-ups
+}
+
+// AnomalyConfig configures the detection of event rate anomalies against baselines
+// learned per namespace and reason
+type AnomalyConfig struct {
+	Enabled    bool            `json:"enabled"`    // Learn event rates and report anomalies
+	Interval   metav1.Duration `json:"interval"`   // Interval the rates are observed and learned over
+	Alpha      float64         `json:"alpha"`      // Weight of the last interval in the moving baselines, between 0 and 1
+	Threshold  float64         `json:"threshold"`  // Standard deviations above or below the baseline reported as an anomaly
+	MinSamples int             `json:"minSamples"` // Intervals learned before a baseline reports anomalies
+	MinRate    float64         `json:"minRate"`    // Events per minute below which a rate is never an anomaly
+	StateFile  string          `json:"stateFile"`  // File the baselines are persisted to across restarts, none when empty
+}
+
+// LogConfig configures the logger
+type LogConfig struct {
+	Level  string `json:"level"`  // Log level (debug, info, warning, error)
+	Format string `json:"format"` // Log format (json or text)
+}
+
+// ClientConfig configures the Kubernetes client
+type ClientConfig struct {
+	QPS   float32 `json:"qps"`   // Queries per second towards the API server
+	Burst int     `json:"burst"` // Maximum burst of queries
+}
+
+// WebSocketConfig configures the WebSocket upgrader
+type WebSocketConfig struct {
+	ReadBufferSize  int      `json:"readBufferSize"`  // Read buffer size in bytes
+	WriteBufferSize int      `json:"writeBufferSize"` // Write buffer size in bytes
+	AllowedOrigins  []string `json:"allowedOrigins"`  // Cross-site origins allowed besides the same origin
+}
+
+// HealthConfig configures the health endpoints
+type HealthConfig struct {
+	WatchFailureThreshold metav1.Duration `json:"watchFailureThreshold"` // How long watches may fail before a cluster is reported unhealthy
+	RequireAllClusters    bool            `json:"requireAllClusters"`    // Fail /readyz when any cluster is unhealthy instead of when all are
+}
+
+// TLSConfig configures TLS serving
+type TLSConfig struct {
+	CertFile       string          `json:"certFile"`       // Serving certificate, empty to serve plain HTTP
+	KeyFile        string          `json:"keyFile"`        // Serving certificate key
+	ClientCAFile   string          `json:"clientCAFile"`   // CAs verifying client certificates
+	ClientAuth     string          `json:"clientAuth"`     // Client certificate mode ("" for none, optional, require)
+	ReloadInterval metav1.Duration `json:"reloadInterval"` // How often the files are checked for changes
+}
+
+// AuthConfig configures client authentication
+type AuthConfig struct {
+	Mode      string          `json:"mode"`      // Authentication mode ("" for none, tokenreview, oidc)
+	Audiences []string        `json:"audiences"` // Audiences tokens must be valid for, empty for the API server's
+	CacheTTL  metav1.Duration `json:"cacheTTL"`  // How long review results are cached
+	OIDC      OIDCConfig      `json:"oidc"`      // OIDC provider settings
+}
+
+// OIDCConfig configures the validation of OIDC ID tokens
+type OIDCConfig struct {
+	IssuerURL       string          `json:"issuerURL"`       // Issuer tokens must come from, also used for discovery
+	ClientID        string          `json:"clientID"`        // Audience tokens must be issued for
+	JWKSURL         string          `json:"jwksURL"`         // Signing keys location, discovered from the issuer when empty
+	JWKSFile        string          `json:"jwksFile"`        // Local signing keys file, used instead of the URL
+	RefreshInterval metav1.Duration `json:"refreshInterval"` // How often signing keys are fetched again
+	UsernameClaim   string          `json:"usernameClaim"`   // Claim holding the user name
+	UsernamePrefix  string          `json:"usernamePrefix"`  // Prefix added to user names
+	GroupsClaim     string          `json:"groupsClaim"`     // Claim holding the user's groups
+	GroupsPrefix    string          `json:"groupsPrefix"`    // Prefix added to group names
+}
+
+// AuthzConfig configures client authorization
+type AuthzConfig struct {
+	Mode            string              `json:"mode"`            // Authorization mode ("" for none, subjectaccessreview, groups)
+	CacheTTL        metav1.Duration     `json:"cacheTTL"`        // How long decisions are cached before being reviewed again
+	GroupNamespaces map[string][]string `json:"groupNamespaces"` // Namespace patterns allowed to each group in groups mode
+}
+
+// RedactionConfig configures the masking of sensitive data in event messages
+type RedactionConfig struct {
+	Detectors []string        `json:"detectors"` // Enabled built-in detectors (token, credentials, email, ip)
+	Rules     []RedactionRule `json:"rules"`     // Custom rules applied after the detectors
+}
+
+// RedactionRule is a custom redaction rule
+type RedactionRule struct {
+	Name        string `json:"name"`        // Rule name, reported with its counter
+	Pattern     string `json:"pattern"`     // Regular expression, masking only its "secret" group when it has one
+	Placeholder string `json:"placeholder"` // Replacement, defaults to [REDACTED:<name>]
+}
+
+// ReplayConfig configures replay mode
+type ReplayConfig struct {
+	File  string  `json:"file"`  // Recording to replay, empty to watch a cluster
+	Speed float64 `json:"speed"` // Speed-up factor (1 = original pace, 0 = as fast as possible)
+}
+
+// RecordConfig configures record mode
+type RecordConfig struct {
+	Dir       string          `json:"dir"`       // Archive directory, empty to disable recording
+	MaxSizeMB int64           `json:"maxSizeMB"` // Rotate files after this many megabytes (0 = never)
+	MaxAge    metav1.Duration `json:"maxAge"`    // Rotate files after this duration (0 = never)
+}
+
+// defaultConfig returns the built-in configuration
+func defaultConfig() Config {
+	return Config{
+		ListenAddress: ":7008",
+		Pods: PodWatcherConfig{
+			InitTimeout:   metav1.Duration{Duration: 5 * time.Minute},
+			FlapThreshold: 4,
+			FlapWindow:    metav1.Duration{Duration: 10 * time.Minute},
+		},
+		Jobs: JobWatcherConfig{
+			MissedScheduleGrace: metav1.Duration{Duration: 2 * time.Minute},
+			SuspendedThreshold:  metav1.Duration{Duration: 24 * time.Hour},
+		},
+		Volumes: VolumeWatcherConfig{PendingThreshold: metav1.Duration{Duration: 2 * time.Minute}},
+		Configs: ConfigWatcherConfig{
+			MaxValueLength: 80,
+			// Token secrets and Helm release history change on their own
+			IgnoreSecretTypes: []string{"kubernetes.io/service-account-token", "helm.sh/release.v1"},
+		},
+		Diffs: DiffConfig{MaxOperations: 10},
+		Alerts: AlertConfig{
+			EvaluationInterval: metav1.Duration{Duration: 15 * time.Second},
+			ResolvedRetention:  metav1.Duration{Duration: time.Hour},
+		},
+		Silences: SilenceConfig{ExpiredRetention: metav1.Duration{Duration: 24 * time.Hour}},
+		Storms:   StormConfig{Window: metav1.Duration{Duration: time.Minute}},
+		Anomalies: AnomalyConfig{
+			Interval:   metav1.Duration{Duration: 5 * time.Minute},
+			Alpha:      0.1,
+			Threshold:  4,
+			MinSamples: 12,
+			MinRate:    1,
+		},
+		Log:       LogConfig{Level: "info", Format: "json"},
+		Client:    ClientConfig{QPS: 5, Burst: 10},
+		WebSocket: WebSocketConfig{ReadBufferSize: 1024, WriteBufferSize: 1024},
+		Outputs:   []string{outputWebSocket, outputLog},
+		Health:    HealthConfig{WatchFailureThreshold: metav1.Duration{Duration: 2 * time.Minute}},
+		Auth: AuthConfig{
+			CacheTTL: metav1.Duration{Duration: time.Minute},
+			OIDC: OIDCConfig{
+				RefreshInterval: metav1.Duration{Duration: time.Hour},
+				UsernameClaim:   "sub",
+				GroupsClaim:     "groups",
+			},
+		},
+		Authorization: AuthzConfig{CacheTTL: metav1.Duration{Duration: time.Minute}},
+		TLS:           TLSConfig{ReloadInterval: metav1.Duration{Duration: 10 * time.Second}},
+		Redaction:     RedactionConfig{Detectors: []string{detectorToken, detectorCredentials, detectorEmail, detectorIP}},
+		Replay:        ReplayConfig{Speed: 1},
+		Record:        RecordConfig{MaxSizeMB: 100, MaxAge: metav1.Duration{Duration: time.Hour}},
+	}
+}
+
+// configOption is a setting that can be given as a flag or an environment variable
+type configOption struct {
+	flag  string                          // Flag name
+	env   string                          // Environment variable name
+	usage string                          // Help text
+	set   func(c *Config, v string) error // Parses v into the configuration
+	get   func(c *Config) string          // Formats the current value
+}
+
+// configOptions lists every setting available as a flag or environment variable
+var configOptions = []configOption{
+	{"listen-address", "TRANSLATOR_LISTEN_ADDRESS", "Address the HTTP server listens on",
+		func(c *Config, v string) error { c.ListenAddress = v; return nil },
+		func(c *Config) string { return c.ListenAddress }},
+	{"tls-cert-file", "TRANSLATOR_TLS_CERT_FILE", "TLS serving certificate file (empty to serve plain HTTP)",
+		func(c *Config, v string) error { c.TLS.CertFile = v; return nil },
+		func(c *Config) string { return c.TLS.CertFile }},
+	{"tls-key-file", "TRANSLATOR_TLS_KEY_FILE", "TLS serving key file",
+		func(c *Config, v string) error { c.TLS.KeyFile = v; return nil },
+		func(c *Config) string { return c.TLS.KeyFile }},
+	{"tls-client-ca-file", "TRANSLATOR_TLS_CLIENT_CA_FILE", "CA bundle verifying client certificates",
+		func(c *Config, v string) error { c.TLS.ClientCAFile = v; return nil },
+		func(c *Config) string { return c.TLS.ClientCAFile }},
+	{"tls-client-auth", "TRANSLATOR_TLS_CLIENT_AUTH", "Client certificate mode (empty for none, optional, require)",
+		func(c *Config, v string) error { c.TLS.ClientAuth = v; return nil },
+		func(c *Config) string { return c.TLS.ClientAuth }},
+	{"tls-reload-interval", "TRANSLATOR_TLS_RELOAD_INTERVAL", "How often TLS files are checked for changes",
+		func(c *Config, v string) (err error) {
+			c.TLS.ReloadInterval.Duration, err = time.ParseDuration(v)
+			return err
+		},
+		func(c *Config) string { return c.TLS.ReloadInterval.Duration.String() }},
+	{"kubeconfig", "KUBECONFIG", "Path to the kubeconfig file (in-cluster configuration when unset inside a pod)",
+		func(c *Config, v string) error { c.Kubeconfig = v; return nil },
+		func(c *Config) string { return c.Kubeconfig }},
+	{"context", "TRANSLATOR_CONTEXT", "Kubeconfig context to use",
+		func(c *Config, v string) error { c.Context = v; return nil },
+		func(c *Config) string { return c.Context }},
+	{"contexts", "TRANSLATOR_CONTEXTS", "Comma-separated kubeconfig contexts of the clusters to watch",
+		func(c *Config, v string) error {
+			c.Clusters = nil
+			for _, context := range splitList(v) {
+				// The kubeconfig is filled in by resolveClusters once every layer is applied
+				c.Clusters = append(c.Clusters, ClusterConfig{Context: context})
+			}
+			return nil
+		},
+		func(c *Config) string {
+			var contexts []string
+			for _, cluster := range c.Clusters {
+				contexts = append(contexts, cluster.Context)
+			}
+			return strings.Join(contexts, ",")
+		}},
+	{"namespaces", "TRANSLATOR_NAMESPACES", "Comma-separated namespaces to watch (empty for all)",
+		func(c *Config, v string) error { c.Namespaces = splitList(v); return nil },
+		func(c *Config) string { return strings.Join(c.Namespaces, ",") }},
+	{"watchers", "TRANSLATOR_WATCHERS", "Comma-separated object trackers to enable besides events (pods, nodes, deployments, jobs, hpas, volumes, configs, services)",
+		func(c *Config, v string) error { c.Watchers = splitList(v); return nil },
+		func(c *Config) string { return strings.Join(c.Watchers, ",") }},
+	{"diffs", "TRANSLATOR_DIFFS", "Report field-level diffs of objects updated while watched by the trackers",
+		func(c *Config, v string) (err error) { c.Diffs.Enabled, err = strconv.ParseBool(v); return err },
+		func(c *Config) string { return strconv.FormatBool(c.Diffs.Enabled) }},
+	{"storm-threshold", "TRANSLATOR_STORM_THRESHOLD", "Events of one namespace, workload and reason within the storm window rolled up as a storm (0 = never)",
+		func(c *Config, v string) (err error) { c.Storms.Threshold, err = strconv.Atoi(v); return err },
+		func(c *Config) string { return strconv.Itoa(c.Storms.Threshold) }},
+	{"storm-window", "TRANSLATOR_STORM_WINDOW", "Window of the storm rates and interval of the storm roll-ups",
+		func(c *Config, v string) (err error) {
+			c.Storms.Window.Duration, err = time.ParseDuration(v)
+			return err
+		},
+		func(c *Config) string { return c.Storms.Window.Duration.String() }},
+	{"anomalies", "TRANSLATOR_ANOMALIES", "Learn event rates per namespace and reason and report anomalies",
+		func(c *Config, v string) (err error) { c.Anomalies.Enabled, err = strconv.ParseBool(v); return err },
+		func(c *Config) string { return strconv.FormatBool(c.Anomalies.Enabled) }},
+	{"anomaly-state-file", "TRANSLATOR_ANOMALY_STATE_FILE", "File the learned event rate baselines are persisted to across restarts",
+		func(c *Config, v string) error { c.Anomalies.StateFile = v; return nil },
+		func(c *Config) string { return c.Anomalies.StateFile }},
+	{"log-level", "TRANSLATOR_LOG_LEVEL", "Log level (debug, info, warning, error)",
+		func(c *Config, v string) error { c.Log.Level = v; return nil },
+		func(c *Config) string { return c.Log.Level }},
+	{"log-format", "TRANSLATOR_LOG_FORMAT", "Log format (json or text)",
+		func(c *Config, v string) error { c.Log.Format = v; return nil },
+		func(c *Config) string { return c.Log.Format }},
+	{"client-qps", "TRANSLATOR_CLIENT_QPS", "Kubernetes client queries per second",
+		func(c *Config, v string) error {
+			qps, err := strconv.ParseFloat(v, 32)
+			c.Client.QPS = float32(qps)
+			return err
+		},
+		func(c *Config) string { return strconv.FormatFloat(float64(c.Client.QPS), 'g', -1, 32) }},
+	{"client-burst", "TRANSLATOR_CLIENT_BURST", "Kubernetes client burst",
+		func(c *Config, v string) (err error) { c.Client.Burst, err = strconv.Atoi(v); return err },
+		func(c *Config) string { return strconv.Itoa(c.Client.Burst) }},
+	{"ws-read-buffer-size", "TRANSLATOR_WS_READ_BUFFER_SIZE", "WebSocket read buffer size in bytes",
+		func(c *Config, v string) (err error) { c.WebSocket.ReadBufferSize, err = strconv.Atoi(v); return err },
+		func(c *Config) string { return strconv.Itoa(c.WebSocket.ReadBufferSize) }},
+	{"ws-write-buffer-size", "TRANSLATOR_WS_WRITE_BUFFER_SIZE", "WebSocket write buffer size in bytes",
+		func(c *Config, v string) (err error) { c.WebSocket.WriteBufferSize, err = strconv.Atoi(v); return err },
+		func(c *Config) string { return strconv.Itoa(c.WebSocket.WriteBufferSize) }},
+	{"ws-allowed-origins", "TRANSLATOR_WS_ALLOWED_ORIGINS", "Comma-separated cross-site origins allowed to connect (e.g. https://*.example.com)",
+		func(c *Config, v string) error { c.WebSocket.AllowedOrigins = splitList(v); return nil },
+		func(c *Config) string { return strings.Join(c.WebSocket.AllowedOrigins, ",") }},
+	{"outputs", "TRANSLATOR_OUTPUTS", "Comma-separated enabled outputs (websocket, log)",
+		func(c *Config, v string) error { c.Outputs = splitList(v); return nil },
+		func(c *Config) string { return strings.Join(c.Outputs, ",") }},
+	{"watch-failure-threshold", "TRANSLATOR_WATCH_FAILURE_THRESHOLD", "How long watches may fail before /readyz reports a cluster unhealthy",
+		func(c *Config, v string) (err error) {
+			c.Health.WatchFailureThreshold.Duration, err = time.ParseDuration(v)
+			return err
+		},
+		func(c *Config) string { return c.Health.WatchFailureThreshold.Duration.String() }},
+	{"require-all-clusters", "TRANSLATOR_REQUIRE_ALL_CLUSTERS", "Report not ready on /readyz when any cluster is unhealthy instead of when all are",
+		func(c *Config, v string) (err error) {
+			c.Health.RequireAllClusters, err = strconv.ParseBool(v)
+			return err
+		},
+		func(c *Config) string { return strconv.FormatBool(c.Health.RequireAllClusters) }},
+	{"auth-mode", "TRANSLATOR_AUTH_MODE", "Client authentication mode (empty for none, tokenreview, oidc)",
+		func(c *Config, v string) error { c.Auth.Mode = v; return nil },
+		func(c *Config) string { return c.Auth.Mode }},
+	{"auth-audiences", "TRANSLATOR_AUTH_AUDIENCES", "Comma-separated audiences bearer tokens must be valid for",
+		func(c *Config, v string) error { c.Auth.Audiences = splitList(v); return nil },
+		func(c *Config) string { return strings.Join(c.Auth.Audiences, ",") }},
+	{"auth-cache-ttl", "TRANSLATOR_AUTH_CACHE_TTL", "How long token review results are cached",
+		func(c *Config, v string) (err error) {
+			c.Auth.CacheTTL.Duration, err = time.ParseDuration(v)
+			return err
+		},
+		func(c *Config) string { return c.Auth.CacheTTL.Duration.String() }},
+	{"oidc-issuer-url", "TRANSLATOR_OIDC_ISSUER_URL", "OIDC issuer URL",
+		func(c *Config, v string) error { c.Auth.OIDC.IssuerURL = v; return nil },
+		func(c *Config) string { return c.Auth.OIDC.IssuerURL }},
+	{"oidc-client-id", "TRANSLATOR_OIDC_CLIENT_ID", "OIDC client ID tokens must be issued for",
+		func(c *Config, v string) error { c.Auth.OIDC.ClientID = v; return nil },
+		func(c *Config) string { return c.Auth.OIDC.ClientID }},
+	{"oidc-jwks-url", "TRANSLATOR_OIDC_JWKS_URL", "OIDC signing keys URL (discovered from the issuer when empty)",
+		func(c *Config, v string) error { c.Auth.OIDC.JWKSURL = v; return nil },
+		func(c *Config) string { return c.Auth.OIDC.JWKSURL }},
+	{"oidc-jwks-file", "TRANSLATOR_OIDC_JWKS_FILE", "Local OIDC signing keys file, used instead of the URL",
+		func(c *Config, v string) error { c.Auth.OIDC.JWKSFile = v; return nil },
+		func(c *Config) string { return c.Auth.OIDC.JWKSFile }},
+	{"oidc-refresh-interval", "TRANSLATOR_OIDC_REFRESH_INTERVAL", "How often OIDC signing keys are fetched again",
+		func(c *Config, v string) (err error) {
+			c.Auth.OIDC.RefreshInterval.Duration, err = time.ParseDuration(v)
+			return err
+		},
+		func(c *Config) string { return c.Auth.OIDC.RefreshInterval.Duration.String() }},
+	{"oidc-username-claim", "TRANSLATOR_OIDC_USERNAME_CLAIM", "OIDC claim holding the user name",
+		func(c *Config, v string) error { c.Auth.OIDC.UsernameClaim = v; return nil },
+		func(c *Config) string { return c.Auth.OIDC.UsernameClaim }},
+	{"oidc-groups-claim", "TRANSLATOR_OIDC_GROUPS_CLAIM", "OIDC claim holding the user's groups",
+		func(c *Config, v string) error { c.Auth.OIDC.GroupsClaim = v; return nil },
+		func(c *Config) string { return c.Auth.OIDC.GroupsClaim }},
+	{"authz-mode", "TRANSLATOR_AUTHZ_MODE", "Client authorization mode (empty for none, subjectaccessreview, groups)",
+		func(c *Config, v string) error { c.Authorization.Mode = v; return nil },
+		func(c *Config) string { return c.Authorization.Mode }},
+	{"authz-cache-ttl", "TRANSLATOR_AUTHZ_CACHE_TTL", "How long authorization decisions are cached",
+		func(c *Config, v string) (err error) {
+			c.Authorization.CacheTTL.Duration, err = time.ParseDuration(v)
+			return err
+		},
+		func(c *Config) string { return c.Authorization.CacheTTL.Duration.String() }},
+	{"redaction-detectors", "TRANSLATOR_REDACTION_DETECTORS", "Comma-separated built-in redaction detectors (token, credentials, email, ip)",
+		func(c *Config, v string) error { c.Redaction.Detectors = splitList(v); return nil },
+		func(c *Config) string { return strings.Join(c.Redaction.Detectors, ",") }},
+	{"replay", "TRANSLATOR_REPLAY", "Serve events from a recording (file or directory) instead of a cluster",
+		func(c *Config, v string) error { c.Replay.File = v; return nil },
+		func(c *Config) string { return c.Replay.File }},
+	{"replay-speed", "TRANSLATOR_REPLAY_SPEED", "Replay speed-up factor (1 = original pace, 0 = as fast as possible)",
+		func(c *Config, v string) (err error) { c.Replay.Speed, err = strconv.ParseFloat(v, 64); return err },
+		func(c *Config) string { return strconv.FormatFloat(c.Replay.Speed, 'g', -1, 64) }},
+	{"record", "TRANSLATOR_RECORD", "Directory to record raw Kubernetes events to as rotated gzip JSONL files",
+		func(c *Config, v string) error { c.Record.Dir = v; return nil },
+		func(c *Config) string { return c.Record.Dir }},
+	{"record-max-size", "TRANSLATOR_RECORD_MAX_SIZE", "Rotate recording files after this many megabytes (0 = never)",
+		func(c *Config, v string) (err error) {
+			c.Record.MaxSizeMB, err = strconv.ParseInt(v, 10, 64)
+			return err
+		},
+		func(c *Config) string { return strconv.FormatInt(c.Record.MaxSizeMB, 10) }},
+	{"record-max-age", "TRANSLATOR_RECORD_MAX_AGE", "Rotate recording files after this duration (0 = never)",
+		func(c *Config, v string) (err error) {
+			c.Record.MaxAge.Duration, err = time.ParseDuration(v)
+			return err
+		},
+		func(c *Config) string { return c.Record.MaxAge.Duration.String() }},
+}
+
+// splitList splits a comma-separated list, dropping empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// flagValue is a flag given on the command line, applied after the file and environment
+type flagValue struct {
+	option configOption // Option the flag belongs to
+	value  string       // Raw flag value
+}
+
+// loadConfig resolves the configuration from defaults, the configuration file,
+// the environment and the command line arguments
+func loadConfig(args []string) (Config, bool, error) {
+	defaults := defaultConfig()
+	fs := flag.NewFlagSet("translator", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv(configFileEnv), "Path to a YAML configuration file (env "+configFileEnv+")")
+	printConfig := fs.Bool("print-config", false, "Print the effective configuration and exit")
+
+	var flags []flagValue
+	for _, option := range configOptions {
+		option := option
+		usage := fmt.Sprintf("%s (env %s)", option.usage, option.env)
+		if value := option.get(&defaults); value != "" {
+			usage += fmt.Sprintf(" (default %q)", value)
+		}
+		fs.Func(option.flag, usage, func(value string) error {
+			flags = append(flags, flagValue{option: option, value: value})
+			return nil
+		})
+	}
+	if err := fs.Parse(args); err != nil {
+		return Config{}, false, err
+	}
+
+	cfg := defaults
+	if *configFile != "" {
+		data, err := os.ReadFile(*configFile)
+		if err != nil {
+			return Config{}, false, err
+		}
+		if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+			return Config{}, false, fmt.Errorf("%s: %w", *configFile, err)
+		}
+	}
+
+	for _, option := range configOptions {
+		if value, ok := os.LookupEnv(option.env); ok {
+			if err := option.set(&cfg, value); err != nil {
+				return Config{}, false, fmt.Errorf("invalid %s: %w", option.env, err)
+			}
+		}
+	}
+
+	for _, f := range flags {
+		if err := f.option.set(&cfg, f.value); err != nil {
+			return Config{}, false, fmt.Errorf("invalid --%s: %w", f.option.flag, err)
+		}
+	}
+
+	return cfg, *printConfig, cfg.validate()
+}
+
+// validate checks the configuration for invalid values
+func (c *Config) validate() error {
+	if _, err := logrus.ParseLevel(c.Log.Level); err != nil {
+		return err
+	}
+	if c.Log.Format != "json" && c.Log.Format != "text" {
+		return fmt.Errorf("unknown log format %q", c.Log.Format)
+	}
+	for _, output := range c.Outputs {
+		if output != outputWebSocket && output != outputLog {
+			return fmt.Errorf("unknown output %q", output)
+		}
+	}
+	for _, origin := range c.WebSocket.AllowedOrigins {
+		if _, err := parseOriginPattern(origin); err != nil {
+			return err
+		}
+	}
+	if c.Auth.Mode != authModeNone && c.Auth.Mode != authModeTokenReview && c.Auth.Mode != authModeOIDC {
+		return fmt.Errorf("unknown authentication mode %q", c.Auth.Mode)
+	}
+	if c.Auth.Mode == authModeOIDC {
+		if c.Auth.OIDC.ClientID == "" {
+			return fmt.Errorf("OIDC authentication requires a client ID")
+		}
+		if c.Auth.OIDC.IssuerURL == "" && c.Auth.OIDC.JWKSURL == "" && c.Auth.OIDC.JWKSFile == "" {
+			return fmt.Errorf("OIDC authentication requires an issuer URL, a JWKS URL or a JWKS file")
+		}
+		if c.Auth.OIDC.UsernameClaim == "" {
+			return fmt.Errorf("OIDC authentication requires a username claim")
+		}
+	}
+	if c.Authorization.Mode != authzModeNone && c.Authorization.Mode != authzModeSubjectAccessReview && c.Authorization.Mode != authzModeGroups {
+		return fmt.Errorf("unknown authorization mode %q", c.Authorization.Mode)
+	}
+	for group, patterns := range c.Authorization.GroupNamespaces {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid namespace pattern %q for group %q", pattern, group)
+			}
+		}
+	}
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		return fmt.Errorf("TLS requires both a certificate and a key file")
+	}
+	if c.TLS.CertFile != "" && c.TLS.ReloadInterval.Duration <= 0 {
+		return fmt.Errorf("TLS reload interval must be positive")
+	}
+	switch c.TLS.ClientAuth {
+	case clientAuthNone:
+	case clientAuthOptional, clientAuthRequire:
+		if c.TLS.CertFile == "" || c.TLS.ClientCAFile == "" {
+			return fmt.Errorf("client certificate verification requires TLS and a client CA file")
+		}
+	default:
+		return fmt.Errorf("unknown client certificate mode %q", c.TLS.ClientAuth)
+	}
+	if c.Authorization.Mode != authzModeNone && c.Auth.Mode == authModeNone && c.TLS.ClientAuth == clientAuthNone {
+		return fmt.Errorf("authorization requires an authentication mode or client certificates")
+	}
+	if c.Authorization.Mode == authzModeSubjectAccessReview && len(c.Clusters) > 1 {
+		// Reviews are made on one cluster and would grant its namespaces in every other
+		return fmt.Errorf("subject access review authorization supports a single cluster, use groups authorization with several")
+	}
+	if c.Authorization.Mode != authzModeNone && c.Authorization.CacheTTL.Duration <= 0 {
+		return fmt.Errorf("authorization cache TTL must be positive")
+	}
+	for _, name := range c.Watchers {
+		if objectTrackers[name] == nil {
+			return fmt.Errorf("unknown watcher %q", name)
+		}
+	}
+	if c.Pods.FlapThreshold < 2 || c.Pods.FlapWindow.Duration <= 0 {
+		return fmt.Errorf("pod readiness flapping needs a threshold of at least 2 and a positive window")
+	}
+	if c.Jobs.MissedScheduleGrace.Duration < 0 || c.Jobs.SuspendedThreshold.Duration <= 0 {
+		return fmt.Errorf("job missed schedule grace must not be negative and suspended threshold must be positive")
+	}
+	if c.Volumes.PendingThreshold.Duration < 0 {
+		return fmt.Errorf("volume pending threshold must not be negative")
+	}
+	if c.Configs.MaxValueLength < 0 {
+		return fmt.Errorf("config max value length must not be negative")
+	}
+	for _, resource := range c.Resources {
+		if resource.Version == "" || resource.Resource == "" {
+			return fmt.Errorf("watched resources need a version and a resource")
+		}
+		if len(resource.Rules) == 0 {
+			return fmt.Errorf("watched resource %s needs at least one rule", resource.Resource)
+		}
+		for _, rule := range resource.Rules {
+			if rule.Name == "" || rule.JSONPath == "" {
+				return fmt.Errorf("rules of watched resource %s need a name and a JSONPath", resource.Resource)
+			}
+		}
+		if _, err := newResourceTracker(resource, nil); err != nil {
+			return err
+		}
+	}
+	if c.Diffs.MaxOperations < 0 {
+		return fmt.Errorf("diff max operations must not be negative")
+	}
+	for kind, kindConfig := range c.Diffs.Kinds {
+		for _, path := range append(append([]string{}, kindConfig.Ignore...), kindConfig.Include...) {
+			if !strings.HasPrefix(path, "/") {
+				return fmt.Errorf("diff path %q of kind %s must start with /", path, kind)
+			}
+		}
+	}
+	for _, path := range c.Diffs.Ignore {
+		if !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("diff path %q must start with /", path)
+		}
+	}
+	if c.Alerts.EvaluationInterval.Duration <= 0 || c.Alerts.ResolvedRetention.Duration < 0 {
+		return fmt.Errorf("alert evaluation interval must be positive and resolved retention must not be negative")
+	}
+	if _, err := newAlertEngine(c.Alerts, nil, nil); err != nil {
+		return err
+	}
+	if c.Storms.Threshold < 0 || c.Storms.Window.Duration <= 0 {
+		return fmt.Errorf("storm threshold must not be negative and storm window must be positive")
+	}
+	if c.Anomalies.Interval.Duration <= 0 || c.Anomalies.Alpha <= 0 || c.Anomalies.Alpha > 1 {
+		return fmt.Errorf("anomaly interval must be positive and anomaly alpha must be between 0 and 1")
+	}
+	if c.Anomalies.Threshold <= 0 || c.Anomalies.MinSamples < 0 || c.Anomalies.MinRate < 0 {
+		return fmt.Errorf("anomaly threshold must be positive, and anomaly minimum samples and rate must not be negative")
+	}
+	if c.Silences.ExpiredRetention.Duration < 0 {
+		return fmt.Errorf("expired silence retention must not be negative")
+	}
+	if _, err := newSilenceStore(c.Silences); err != nil {
+		return err
+	}
+	if _, err := newRedactor(c.Redaction); err != nil {
+		return err
+	}
+	if c.Replay.File != "" && c.Record.Dir != "" {
+		return fmt.Errorf("replay and record cannot be used together")
+	}
+	names := map[string]bool{}
+	for _, cluster := range c.Clusters {
+		name := clusterName(cluster)
+		if names[name] {
+			return fmt.Errorf("duplicate cluster name %q", name)
+		}
+		names[name] = true
+	}
+	if c.Replay.Speed < 0 {
+		return fmt.Errorf("replay speed must not be negative")
+	}
+	return nil
+}
+
+// outputEnabled reports whether an output is enabled
+func (c *Config) outputEnabled(output string) bool {
+	for _, enabled := range c.Outputs {
+		if enabled == output {
+			return true
+		}
+	}
+	return false
+}
+
+// print writes the configuration as YAML, followed by the variables that can override it
+func (c *Config) print(w io.Writer) error {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+
+	var envs []string
+	for _, option := range configOptions {
+		if _, ok := os.LookupEnv(option.env); ok {
+			envs = append(envs, option.env)
+		}
+	}
+	sort.Strings(envs)
+	if len(envs) > 0 {
+		_, err = fmt.Fprintf(w, "# overridden by environment: %s\n", strings.Join(envs, ", "))
+	}
+	return err
+}
+
+// configureLogger applies the logging configuration
+func (c *Config) configureLogger() {
+	level, _ := logrus.ParseLevel(c.Log.Level) // Validated by loadConfig
+	log.Level = level
+	if c.Log.Format == "text" {
+		log.Formatter = &logrus.TextFormatter{}
+	} else {
+		log.Formatter = &logrus.JSONFormatter{}
+	}
+}
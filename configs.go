@@ -0,0 +1,301 @@
+package main
+
+import (
+	"crypto/hmac"   // Keyed hashes of secret values
+	"crypto/rand"   // Random hash key
+	"crypto/sha256" // Hash function
+	"encoding/hex"  // Hash formatting
+	"fmt"           // Message formatting
+	"sort"          // Ordering keys
+	"strings"       // String manipulation
+	"unicode/utf8"  // Truncating values
+
+	appsv1 "k8s.io/api/apps/v1"                   // Apps v1 API for Kubernetes
+	v1 "k8s.io/api/core/v1"                       // Core v1 API for Kubernetes
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1" // Meta v1 API for Kubernetes
+	"k8s.io/apimachinery/pkg/fields"              // For selecting Kubernetes fields
+	"k8s.io/client-go/kubernetes"                 // Kubernetes client
+	"k8s.io/client-go/tools/cache"                // For caching Kubernetes objects
+)
+
+// Number of hex digits of the hashes shown for secret values
+const secretHashLength = 12
+
+// configTracker reports ConfigMap and Secret changes as events with a key-level
+// diff and the Deployments using the object. ConfigMap values are shown truncated,
+// Secret values only as keyed hashes.
+type configTracker struct {
+	emit        func(*v1.Event)     // Receiver of the synthetic events
+	config      ConfigWatcherConfig // Diff settings
+	hashKey     []byte              // Key of the secret value hashes, random for each process
+	deployments []*namespaceWatch   // Deployments of every watched namespace
+}
+
+// newConfigTracker creates a config tracker using the configs settings
+func newConfigTracker() objectTracker {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		log.WithField("error", err).Fatal("Failed to generate the secret hash key")
+	}
+	return &configTracker{config: cfg.Configs, hashKey: key}
+}
+
+// start runs ConfigMap, Secret and Deployment informers for every watched namespace
+func (t *configTracker) start(w *clusterWatcher, clientset kubernetes.Interface, stop <-chan struct{}) []cache.InformerSynced {
+	t.emit = w.emit
+	restClient := clientset.CoreV1().RESTClient()
+
+	var synced []cache.InformerSynced
+	for _, namespace := range w.namespaces {
+		deployments := w.sharedInformer("deployments", namespace,
+			cache.NewListWatchFromClient(clientset.AppsV1().RESTClient(), "deployments", namespace, fields.Everything()),
+			&appsv1.Deployment{}, 0, cache.ResourceEventHandlerFuncs{}, false)
+		t.deployments = append(t.deployments, deployments)
+
+		configMaps := w.sharedInformer("configmaps", namespace,
+			cache.NewListWatchFromClient(restClient, "configmaps", namespace, fields.Everything()),
+			&v1.ConfigMap{}, 0, t.handler(func(obj interface{}) (metav1.Object, map[string]string, bool) {
+				configMap, ok := obj.(*v1.ConfigMap)
+				if !ok {
+					return nil, nil, false
+				}
+				return configMap, t.configMapValues(configMap), true
+			}), true)
+
+		secrets := w.sharedInformer("secrets", namespace,
+			cache.NewListWatchFromClient(restClient, "secrets", namespace, fields.Everything()),
+			&v1.Secret{}, 0, t.handler(func(obj interface{}) (metav1.Object, map[string]string, bool) {
+				secret, ok := obj.(*v1.Secret)
+				if !ok || t.ignoredSecretType(secret.Type) {
+					return nil, nil, false
+				}
+				return secret, t.secretValues(secret), true
+			}), true)
+		synced = append(synced, deployments.informer.HasSynced, configMaps.informer.HasSynced, secrets.informer.HasSynced)
+	}
+	return synced
+}
+
+// handler returns the informer handler reporting objects being created, changed and
+// deleted, using values to read an object and the displayable values of its keys
+func (t *configTracker) handler(values func(interface{}) (metav1.Object, map[string]string, bool)) cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerDetailedFuncs{
+		AddFunc: func(obj interface{}, isInInitialList bool) {
+			if object, data, ok := values(obj); ok && !isInInitialList {
+				t.emitFor(object, "Created", v1.EventTypeNormal, fmt.Sprintf("created with keys %s", describeKeys(data)))
+			}
+		},
+		UpdateFunc: func(old, obj interface{}) {
+			_, oldData, ok1 := values(old)
+			object, data, ok2 := values(obj)
+			if !ok1 || !ok2 {
+				return
+			}
+			if changes := diffValues(oldData, data); len(changes) > 0 {
+				t.emitFor(object, "Changed", v1.EventTypeNormal, "changed: "+strings.Join(changes, ", "))
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if object, _, ok := values(obj); ok {
+				t.emitFor(object, "Deleted", v1.EventTypeNormal, "deleted")
+			}
+		},
+	}
+}
+
+// configMapValues returns the truncated values of a ConfigMap by key, binary values
+// as their size
+func (t *configTracker) configMapValues(configMap *v1.ConfigMap) map[string]string {
+	values := make(map[string]string, len(configMap.Data)+len(configMap.BinaryData))
+	for key, value := range configMap.Data {
+		values[key] = fmt.Sprintf("%q", truncate(value, t.config.MaxValueLength))
+	}
+	for key, value := range configMap.BinaryData {
+		values[key] = fmt.Sprintf("<%d bytes, %s>", len(value), t.hash(value))
+	}
+	return values
+}
+
+// secretValues returns the hashes of the values of a Secret by key
+func (t *configTracker) secretValues(secret *v1.Secret) map[string]string {
+	values := make(map[string]string, len(secret.Data)+len(secret.StringData))
+	for key, value := range secret.Data {
+		values[key] = "hash " + t.hash(value)
+	}
+	// stringData is write-only and merged into data by the API server, but keep it
+	// for objects built by clients
+	for key, value := range secret.StringData {
+		values[key] = "hash " + t.hash([]byte(value))
+	}
+	return values
+}
+
+// hash returns a short keyed hash of a value, which tells changes apart without
+// allowing anyone to guess the value from it
+func (t *configTracker) hash(value []byte) string {
+	mac := hmac.New(sha256.New, t.hashKey)
+	mac.Write(value)
+	return hex.EncodeToString(mac.Sum(nil))[:secretHashLength]
+}
+
+// ignoredSecretType reports whether changes to secrets of a type are not reported
+func (t *configTracker) ignoredSecretType(secretType v1.SecretType) bool {
+	for _, ignored := range t.config.IgnoreSecretTypes {
+		if string(secretType) == ignored {
+			return true
+		}
+	}
+	return false
+}
+
+// diffValues lists the keys added, removed and changed between two sets of values
+func diffValues(old, values map[string]string) []string {
+	var changes []string
+	for _, key := range sortedKeys(values) {
+		previous, ok := old[key]
+		switch {
+		case !ok:
+			changes = append(changes, fmt.Sprintf("%s added %s", key, values[key]))
+		case previous != values[key]:
+			changes = append(changes, fmt.Sprintf("%s %s -> %s", key, previous, values[key]))
+		}
+	}
+	for _, key := range sortedKeys(old) {
+		if _, ok := values[key]; !ok {
+			changes = append(changes, key+" removed")
+		}
+	}
+	return changes
+}
+
+// describeKeys formats the sorted keys of a set of values
+func describeKeys(values map[string]string) string {
+	if len(values) == 0 {
+		return "<none>"
+	}
+	return strings.Join(sortedKeys(values), ", ")
+}
+
+// sortedKeys returns the keys of a map in order
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// truncate shortens a value to at most max characters, marking the cut
+func truncate(value string, max int) string {
+	if max <= 0 || utf8.RuneCountInString(value) <= max {
+		return value
+	}
+	runes := []rune(value)
+	return string(runes[:max]) + "..."
+}
+
+// consumers describes the Deployments using a ConfigMap or Secret and how. Consumers
+// reading it from the environment only see the change once their pods restart.
+func (t *configTracker) consumers(kind string, object metav1.Object) []string {
+	var consumers []string
+	for _, nw := range t.deployments {
+		for _, obj := range nw.informer.GetStore().List() {
+			deployment, ok := obj.(*appsv1.Deployment)
+			if !ok || deployment.Namespace != object.GetNamespace() {
+				continue
+			}
+			if uses := podSpecUses(&deployment.Spec.Template.Spec, kind, object.GetName()); uses != "" {
+				consumers = append(consumers, fmt.Sprintf("deployment %s (%s)", deployment.Name, uses))
+			}
+		}
+	}
+	sort.Strings(consumers)
+	return consumers
+}
+
+// podSpecUses describes the ways a pod spec uses a ConfigMap or Secret: volume,
+// env, envFrom or imagePullSecrets
+func podSpecUses(spec *v1.PodSpec, kind, name string) string {
+	uses := map[string]bool{}
+	for _, volume := range spec.Volumes {
+		if volumeUses(volume.VolumeSource, kind, name) {
+			uses["volume"] = true
+		}
+	}
+	for _, container := range podContainers(*spec) {
+		for _, source := range container.EnvFrom {
+			if (kind == "ConfigMap" && source.ConfigMapRef != nil && source.ConfigMapRef.Name == name) ||
+				(kind == "Secret" && source.SecretRef != nil && source.SecretRef.Name == name) {
+				uses["envFrom"] = true
+			}
+		}
+		for _, env := range container.Env {
+			if from := env.ValueFrom; from != nil &&
+				((kind == "ConfigMap" && from.ConfigMapKeyRef != nil && from.ConfigMapKeyRef.Name == name) ||
+					(kind == "Secret" && from.SecretKeyRef != nil && from.SecretKeyRef.Name == name)) {
+				uses["env"] = true
+			}
+		}
+	}
+	if kind == "Secret" {
+		for _, ref := range spec.ImagePullSecrets {
+			if ref.Name == name {
+				uses["imagePullSecrets"] = true
+			}
+		}
+	}
+	if len(uses) == 0 {
+		return ""
+	}
+
+	list := make([]string, 0, len(uses))
+	for use := range uses {
+		list = append(list, use)
+	}
+	sort.Strings(list)
+	description := strings.Join(list, ", ")
+	if uses["env"] || uses["envFrom"] {
+		description += "; env needs a restart"
+	}
+	return description
+}
+
+// volumeUses reports whether a volume mounts a ConfigMap or Secret, directly or projected
+func volumeUses(source v1.VolumeSource, kind, name string) bool {
+	if kind == "ConfigMap" && source.ConfigMap != nil && source.ConfigMap.Name == name {
+		return true
+	}
+	if kind == "Secret" && source.Secret != nil && source.Secret.SecretName == name {
+		return true
+	}
+	if source.Projected != nil {
+		for _, projection := range source.Projected.Sources {
+			if (kind == "ConfigMap" && projection.ConfigMap != nil && projection.ConfigMap.Name == name) ||
+				(kind == "Secret" && projection.Secret != nil && projection.Secret.Name == name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// emitFor emits an event about a ConfigMap or Secret, naming the Deployments using it
+func (t *configTracker) emitFor(object metav1.Object, change, severity, message string) {
+	kind := "ConfigMap"
+	if _, ok := object.(*v1.Secret); ok {
+		kind = "Secret"
+	}
+	message = fmt.Sprintf("%s %s %s", strings.ToLower(kind), object.GetName(), message)
+	if consumers := t.consumers(kind, object); len(consumers) > 0 {
+		message += "; used by " + strings.Join(consumers, ", ")
+		// Removing an object still in use breaks the next pod start
+		if change == "Deleted" {
+			severity = v1.EventTypeWarning
+		}
+	}
+	t.emit(syntheticEvent(kind, object, kind+change, severity, message))
+}
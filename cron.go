@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"     // Error formatting
+	"strconv" // Parsing numbers
+	"strings" // String manipulation
+	"time"    // For time-related operations
+)
+
+// Predefined schedules accepted by CronJobs
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// cronField describes the values allowed in one field of a schedule
+type cronField struct {
+	min, max int            // Allowed range
+	names    map[string]int // Names accepted instead of numbers
+}
+
+// Names accepted in the month and day of week fields
+var (
+	cronMonthNames = map[string]int{"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6, "jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12}
+	cronDayNames   = map[string]int{"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6}
+)
+
+// Fields of a schedule, in order
+var cronFields = []cronField{
+	{0, 59, nil},            // Minute
+	{0, 23, nil},            // Hour
+	{1, 31, nil},            // Day of month
+	{1, 12, cronMonthNames}, // Month
+	{0, 7, cronDayNames},    // Day of week, 7 being Sunday
+}
+
+// cronSchedule is a parsed standard cron expression, or the interval of an @every schedule
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64         // Allowed values as bit sets
+	domStar, dowStar              bool           // Whether the day fields were unrestricted
+	location                      *time.Location // Time zone the schedule is evaluated in
+	every                         time.Duration  // Interval of @every schedules, zero for expressions
+}
+
+// parseCron parses a five-field cron expression, macro or "@every <duration>",
+// optionally prefixed with CRON_TZ= or TZ=, evaluated in location unless the
+// prefix names another zone
+func parseCron(spec string, location *time.Location) (*cronSchedule, error) {
+	spec = strings.TrimSpace(spec)
+	for _, prefix := range []string{"CRON_TZ=", "TZ="} {
+		if strings.HasPrefix(spec, prefix) {
+			zone, rest, _ := strings.Cut(strings.TrimPrefix(spec, prefix), " ")
+			loc, err := time.LoadLocation(zone)
+			if err != nil {
+				return nil, fmt.Errorf("invalid time zone %q: %w", zone, err)
+			}
+			location, spec = loc, strings.TrimSpace(rest)
+		}
+	}
+	if macro, ok := cronMacros[strings.ToLower(spec)]; ok {
+		spec = macro
+	}
+	if interval, ok := strings.CutPrefix(spec, "@every "); ok {
+		every, err := time.ParseDuration(strings.TrimSpace(interval))
+		if err != nil || every <= 0 {
+			return nil, fmt.Errorf("invalid interval %q", interval)
+		}
+		// Like the CronJob controller, intervals are whole seconds of at least one
+		if every = every.Truncate(time.Second); every < time.Second {
+			every = time.Second
+		}
+		return &cronSchedule{location: location, every: every}, nil
+	}
+
+	parts := strings.Fields(spec)
+	if len(parts) != len(cronFields) {
+		return nil, fmt.Errorf("invalid cron expression %q: expected 5 fields", spec)
+	}
+	values := make([]uint64, len(parts))
+	for i, part := range parts {
+		bits, err := parseCronField(part, cronFields[i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression %q: %w", spec, err)
+		}
+		values[i] = bits
+	}
+	// Sunday can be written 0 or 7
+	if values[4]&(1<<7) != 0 {
+		values[4] |= 1
+	}
+
+	return &cronSchedule{
+		minute:   values[0],
+		hour:     values[1],
+		dom:      values[2],
+		month:    values[3],
+		dow:      values[4],
+		domStar:  strings.HasPrefix(parts[2], "*") || strings.HasPrefix(parts[2], "?"),
+		dowStar:  strings.HasPrefix(parts[4], "*") || strings.HasPrefix(parts[4], "?"),
+		location: location,
+	}, nil
+}
+
+// parseCronField parses a comma-separated list of values, ranges and steps
+func parseCronField(field string, bounds cronField) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepPart); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q", stepPart)
+			}
+		}
+
+		low, high := bounds.min, bounds.max
+		if rangePart != "*" && rangePart != "?" {
+			lowPart, highPart, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if low, err = parseCronValue(lowPart, bounds); err != nil {
+				return 0, err
+			}
+			high = low
+			if isRange {
+				if high, err = parseCronValue(highPart, bounds); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				// "5/15" means from 5 to the end of the range every 15
+				high = bounds.max
+			}
+		}
+		if low > high {
+			return 0, fmt.Errorf("invalid range %q", rangePart)
+		}
+		for v := low; v <= high; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// parseCronValue parses a number or name within the bounds of a field
+func parseCronValue(value string, bounds cronField) (int, error) {
+	if n, ok := bounds.names[strings.ToLower(value)]; ok {
+		return n, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < bounds.min || n > bounds.max {
+		return 0, fmt.Errorf("invalid value %q", value)
+	}
+	return n, nil
+}
+
+// dayMatches reports whether the schedule runs on the day of t. As in cron, a day
+// matches either day field when both are restricted, and both otherwise.
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// next returns the first time after t the schedule runs at, zero when it never does.
+// Times skipped by a DST change are skipped, and times repeated by one run once.
+func (s *cronSchedule) next(t time.Time) time.Time {
+	if s.every > 0 {
+		return t.Add(s.every - time.Duration(t.Nanosecond()))
+	}
+	from := wallClock(t.In(s.location))
+	t = t.In(s.location).Truncate(time.Minute).Add(time.Minute)
+	limit := t.Year() + 5
+
+	for t.Year() <= limit {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = later(t, time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, s.location))
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = later(t, time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, s.location))
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			// Stepping in elapsed time crosses a DST gap that time.Date could normalize backwards
+			t = t.Add(time.Duration(60-t.Minute()) * time.Minute)
+			continue
+		}
+		// The second pass over the hour repeated when clocks go back is not a new time
+		if s.minute&(1<<uint(t.Minute())) == 0 || !wallClock(t).After(from) {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// later returns next, or the start of the hour after t when next is a midnight
+// skipped by a DST change that time.Date normalized to before t
+func later(t, next time.Time) time.Time {
+	if next.After(t) {
+		return next
+	}
+	return t.Add(time.Duration(60-t.Minute()) * time.Minute)
+}
+
+// wallClock returns the date and time shown by the clock at t, without its zone
+func wallClock(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+}
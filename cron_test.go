@@ -0,0 +1,130 @@
+package main
+
+import (
+	"testing" // Test framework
+	"time"    // For time-related operations
+
+	_ "time/tzdata" // Time zones independent of the host
+
+	batchv1 "k8s.io/api/batch/v1"                 // Batch v1 API for Kubernetes
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1" // Meta v1 API for Kubernetes
+)
+
+// mustLocation loads a time zone or fails the test
+func mustLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+func TestCronNext(t *testing.T) {
+	tests := []struct {
+		name string   // Case description
+		spec string   // Schedule
+		loc  string   // Time zone of the schedule
+		from string   // Time the runs are searched from, RFC 3339
+		want []string // Following runs, RFC 3339
+	}{
+		{"every minute", "* * * * *", "UTC", "2024-05-01T10:00:30Z",
+			[]string{"2024-05-01T10:01:00Z", "2024-05-01T10:02:00Z"}},
+		{"range", "0 9-11 * * *", "UTC", "2024-05-01T10:30:00Z",
+			[]string{"2024-05-01T11:00:00Z", "2024-05-02T09:00:00Z", "2024-05-02T10:00:00Z"}},
+		{"step", "*/20 * * * *", "UTC", "2024-05-01T10:05:00Z",
+			[]string{"2024-05-01T10:20:00Z", "2024-05-01T10:40:00Z", "2024-05-01T11:00:00Z"}},
+		{"step from a value", "5/20 * * * *", "UTC", "2024-05-01T10:00:00Z",
+			[]string{"2024-05-01T10:05:00Z", "2024-05-01T10:25:00Z", "2024-05-01T10:45:00Z", "2024-05-01T11:05:00Z"}},
+		{"stepped range", "0 0-12/6 * * *", "UTC", "2024-05-01T01:00:00Z",
+			[]string{"2024-05-01T06:00:00Z", "2024-05-01T12:00:00Z", "2024-05-02T00:00:00Z"}},
+		{"list and names", "0 0 1 jan,jul *", "UTC", "2024-05-01T00:00:00Z",
+			[]string{"2024-07-01T00:00:00Z", "2025-01-01T00:00:00Z"}},
+		{"day of week only", "0 0 * * fri", "UTC", "2024-05-01T00:00:00Z",
+			[]string{"2024-05-03T00:00:00Z", "2024-05-10T00:00:00Z"}},
+		{"day of month or day of week", "0 0 13 * 5", "UTC", "2024-05-01T00:00:00Z",
+			[]string{"2024-05-03T00:00:00Z", "2024-05-10T00:00:00Z", "2024-05-13T00:00:00Z", "2024-05-17T00:00:00Z"}},
+		{"day of month with day of week star", "0 0 13 * *", "UTC", "2024-05-01T00:00:00Z",
+			[]string{"2024-05-13T00:00:00Z", "2024-06-13T00:00:00Z"}},
+		{"sunday as 7", "0 0 * * 7", "UTC", "2024-05-01T00:00:00Z",
+			[]string{"2024-05-05T00:00:00Z", "2024-05-12T00:00:00Z"}},
+		{"leap day", "0 0 29 2 *", "UTC", "2024-03-01T00:00:00Z",
+			[]string{"2028-02-29T00:00:00Z"}},
+		{"macro", "@daily", "UTC", "2024-05-01T10:00:00Z",
+			[]string{"2024-05-02T00:00:00Z", "2024-05-03T00:00:00Z"}},
+		{"every", "@every 90m", "UTC", "2024-05-01T10:00:00.5Z",
+			[]string{"2024-05-01T11:30:00Z", "2024-05-01T13:00:00Z"}},
+		{"time zone", "0 9 * * *", "America/New_York", "2024-05-01T12:00:00Z",
+			[]string{"2024-05-01T13:00:00Z", "2024-05-02T13:00:00Z"}},
+		{"time zone prefix", "CRON_TZ=Asia/Tokyo 0 9 * * *", "UTC", "2024-05-01T00:00:00Z",
+			[]string{"2024-05-02T00:00:00Z"}},
+		{"time skipped by DST", "30 2 * * *", "America/New_York", "2024-03-09T12:00:00Z",
+			[]string{"2024-03-11T06:30:00Z"}},
+		{"time repeated by DST runs once", "30 1 * * *", "America/New_York", "2024-11-03T04:00:00Z",
+			[]string{"2024-11-03T05:30:00Z", "2024-11-04T06:30:00Z"}},
+		{"hourly across DST end", "0 * * * *", "America/New_York", "2024-11-03T04:30:00Z",
+			[]string{"2024-11-03T05:00:00Z", "2024-11-03T07:00:00Z", "2024-11-03T08:00:00Z"}},
+		{"never", "0 0 31 2 *", "UTC", "2024-05-01T00:00:00Z",
+			[]string{"0001-01-01T00:00:00Z"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := parseCron(tt.spec, mustLocation(t, tt.loc))
+			if err != nil {
+				t.Fatal(err)
+			}
+			at, err := time.Parse(time.RFC3339Nano, tt.from)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range tt.want {
+				at = schedule.next(at)
+				if got := at.UTC().Format(time.RFC3339); got != want {
+					t.Fatalf("next run %s, want %s", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"* * * foo *",
+		"@every",
+		"@every 0s",
+		"@every soon",
+		"TZ=Nowhere/Special * * * * *",
+	} {
+		if _, err := parseCron(spec, time.UTC); err == nil {
+			t.Errorf("parseCron(%q) succeeded, want an error", spec)
+		}
+	}
+}
+
+func TestMissedScheduleTimeZone(t *testing.T) {
+	zone := "Asia/Tokyo"
+	created := metav1.NewTime(time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC))
+	cronJob := &batchv1.CronJob{
+		ObjectMeta: metav1.ObjectMeta{CreationTimestamp: created},
+		Spec:       batchv1.CronJobSpec{Schedule: "0 9 * * *", TimeZone: &zone},
+	}
+
+	// 09:00 in Tokyo is 00:00 UTC, so the first run after creation is the next day
+	missed, err := missedSchedule(cronJob, time.Date(2024, 5, 2, 0, 1, 0, 0, time.UTC), 2*time.Minute)
+	if err != nil || !missed.IsZero() {
+		t.Fatalf("missed %v, %v within the grace period", missed, err)
+	}
+	missed, err = missedSchedule(cronJob, time.Date(2024, 5, 2, 0, 5, 0, 0, time.UTC), 2*time.Minute)
+	if err != nil || !missed.Equal(time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("missed %v, %v, want the run of 2024-05-02T00:00:00Z", missed, err)
+	}
+}
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"encoding/json" // Encoding diffs into events
+	"fmt"           // Message formatting
+	"reflect"       // Comparing values and naming kinds
+	"sort"          // Ordering keys
+	"strconv"       // Formatting array indexes
+	"strings"       // String manipulation
+
+	"github.com/sirupsen/logrus"                        // Package for structured logging
+	v1 "k8s.io/api/core/v1"                             // Core v1 API for Kubernetes
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"       // Meta v1 API for Kubernetes
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured" // Objects of any type
+	"k8s.io/apimachinery/pkg/runtime"                   // Kubernetes runtime objects
+	"k8s.io/apimachinery/pkg/watch"                     // Watch event types
+	"k8s.io/client-go/tools/cache"                      // For caching Kubernetes objects
+)
+
+// Annotation of synthetic events carrying the diff of the updated object
+const diffAnnotation = "k8s-translator/diff"
+
+// Characters of a value shown in diff messages
+const diffValueLength = 80
+
+// Fields ignored in the diffs of every kind: bookkeeping and timestamps that change
+// without anyone changing the object
+var defaultDiffIgnore = []string{
+	"/metadata/managedFields",
+	"/metadata/resourceVersion",
+	"/metadata/generation",
+	"/metadata/annotations/kubectl.kubernetes.io~1last-applied-configuration",
+	"/status/observedGeneration",
+	"/**/lastTransitionTime",
+	"/**/lastUpdateTime",
+	"/**/lastHeartbeatTime",
+	"/**/lastProbeTime",
+	"/**/startedAt",
+	"/**/finishedAt",
+	"/**/lastScaleTime",
+	"/**/lastScheduleTime",
+	"/**/renewTime",
+}
+
+// Fields never diffed whatever the configuration says, as they hold secrets or
+// values the configs tracker reports by key, truncated
+var sensitiveDiffPaths = map[string][]string{
+	"Secret":    {"/data", "/stringData"},
+	"ConfigMap": {"/data", "/binaryData"},
+}
+
+// patchOperation is one change of a JSON-patch-style diff
+type patchOperation struct {
+	Op       string      `json:"op"`                 // add, remove or replace
+	Path     string      `json:"path"`               // JSON pointer of the changed field
+	Value    interface{} `json:"value,omitempty"`    // New value of added and replaced fields
+	OldValue interface{} `json:"oldValue,omitempty"` // Previous value of replaced and removed fields
+}
+
+// diffOptions are the ignore and include patterns applied to the diffs of a kind
+type diffOptions struct {
+	ignore  []string // Fields left out of the diff
+	include []string // Fields kept in the diff, empty for all
+}
+
+// diffOptionsFor returns the patterns applying to a kind
+func diffOptionsFor(config DiffConfig, kind string) diffOptions {
+	options := diffOptions{ignore: append(append([]string{}, defaultDiffIgnore...), config.Ignore...)}
+	options.ignore = append(options.ignore, sensitiveDiffPaths[kind]...)
+	if kindConfig, ok := config.Kinds[kind]; ok {
+		options.ignore = append(options.ignore, kindConfig.Ignore...)
+		options.include = kindConfig.Include
+	}
+	return options
+}
+
+// keeps reports whether a change at path is part of the diff. With parents, the
+// parents of included fields are kept too, to look into them or to report a
+// struct or list holding included fields being added or removed.
+func (o diffOptions) keeps(path []string, parents bool) bool {
+	for _, pattern := range o.ignore {
+		if matchesPathPrefix(splitPointer(pattern), path) {
+			return false
+		}
+	}
+	if len(o.include) == 0 {
+		return true
+	}
+	for _, pattern := range o.include {
+		if matchesPathPrefix(splitPointer(pattern), path) || (parents && matchesPathParent(splitPointer(pattern), path)) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesPathPrefix reports whether pattern matches path or one of its parents. A
+// "*" segment matches any one segment and "**" any number of them.
+func matchesPathPrefix(pattern, path []string) bool {
+	if len(pattern) == 0 {
+		return true
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(path); i++ {
+			if matchesPathPrefix(pattern[1:], path[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(path) == 0 || (pattern[0] != "*" && pattern[0] != path[0]) {
+		return false
+	}
+	return matchesPathPrefix(pattern[1:], path[1:])
+}
+
+// matchesPathParent reports whether path is a parent of fields matched by pattern
+func matchesPathParent(pattern, path []string) bool {
+	if len(path) == 0 {
+		return true
+	}
+	if len(pattern) == 0 {
+		return false
+	}
+	if pattern[0] == "**" {
+		return true
+	}
+	if pattern[0] != "*" && pattern[0] != path[0] {
+		return false
+	}
+	return matchesPathParent(pattern[1:], path[1:])
+}
+
+// splitPointer splits a JSON pointer into unescaped segments
+func splitPointer(pointer string) []string {
+	pointer = strings.Trim(pointer, "/")
+	if pointer == "" {
+		return nil
+	}
+	segments := strings.Split(pointer, "/")
+	for i, segment := range segments {
+		segments[i] = strings.ReplaceAll(strings.ReplaceAll(segment, "~1", "/"), "~0", "~")
+	}
+	return segments
+}
+
+// joinPointer builds a JSON pointer from segments
+func joinPointer(segments []string) string {
+	var b strings.Builder
+	for _, segment := range segments {
+		b.WriteString("/")
+		b.WriteString(strings.ReplaceAll(strings.ReplaceAll(segment, "~", "~0"), "/", "~1"))
+	}
+	return b.String()
+}
+
+// diffObjects returns the changes between two versions of an object, keeping only
+// the fields allowed by options
+func diffObjects(old, object interface{}, options diffOptions) ([]patchOperation, error) {
+	oldContent, err := objectContent(old)
+	if err != nil {
+		return nil, err
+	}
+	content, err := objectContent(object)
+	if err != nil {
+		return nil, err
+	}
+	var operations []patchOperation
+	diffFields(nil, oldContent, content, options, &operations)
+	return operations, nil
+}
+
+// objectContent returns an object as nested maps
+func objectContent(obj interface{}) (map[string]interface{}, error) {
+	if u, ok := obj.(*unstructured.Unstructured); ok {
+		return u.Object, nil
+	}
+	return runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+}
+
+// diffFields appends the changes between two values at path to operations. Maps
+// are compared key by key and lists index by index.
+func diffFields(path []string, old, value interface{}, options diffOptions, operations *[]patchOperation) {
+	if !options.keeps(path, true) {
+		return
+	}
+
+	oldMap, oldIsMap := old.(map[string]interface{})
+	newMap, newIsMap := value.(map[string]interface{})
+	if oldIsMap && newIsMap {
+		keys := map[string]bool{}
+		for key := range oldMap {
+			keys[key] = true
+		}
+		for key := range newMap {
+			keys[key] = true
+		}
+		sorted := make([]string, 0, len(keys))
+		for key := range keys {
+			sorted = append(sorted, key)
+		}
+		sort.Strings(sorted)
+		for _, key := range sorted {
+			child := append(append([]string{}, path...), key)
+			oldChild, inOld := oldMap[key]
+			newChild, inNew := newMap[key]
+			switch {
+			case !inOld:
+				addOperation(child, "add", nil, newChild, options, operations)
+			case !inNew:
+				addOperation(child, "remove", oldChild, nil, options, operations)
+			default:
+				diffFields(child, oldChild, newChild, options, operations)
+			}
+		}
+		return
+	}
+
+	oldList, oldIsList := old.([]interface{})
+	newList, newIsList := value.([]interface{})
+	if oldIsList && newIsList {
+		for i := 0; i < len(oldList) || i < len(newList); i++ {
+			child := append(append([]string{}, path...), strconv.Itoa(i))
+			switch {
+			case i >= len(oldList):
+				addOperation(child, "add", nil, newList[i], options, operations)
+			case i >= len(newList):
+				addOperation(child, "remove", oldList[i], nil, options, operations)
+			default:
+				diffFields(child, oldList[i], newList[i], options, operations)
+			}
+		}
+		return
+	}
+
+	if !reflect.DeepEqual(old, value) {
+		addOperation(path, "replace", old, value, options, operations)
+	}
+}
+
+// addOperation appends an operation when its path is kept, or when it adds or
+// removes a struct or list holding kept fields
+func addOperation(path []string, op string, old, value interface{}, options diffOptions, operations *[]patchOperation) {
+	content := value
+	if op == "remove" {
+		content = old
+	}
+	if options.keeps(path, false) || (op != "replace" && holdsKeptFields(path, content, options)) {
+		*operations = append(*operations, patchOperation{Op: op, Path: joinPointer(path), Value: value, OldValue: old})
+	}
+}
+
+// holdsKeptFields reports whether a struct or list at path holds fields kept in the diff
+func holdsKeptFields(path []string, value interface{}, options diffOptions) bool {
+	if !options.keeps(path, true) {
+		return false
+	}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			child := append(append([]string{}, path...), key)
+			if options.keeps(child, false) || holdsKeptFields(child, item, options) {
+				return true
+			}
+		}
+	case []interface{}:
+		for i, item := range v {
+			child := append(append([]string{}, path...), strconv.Itoa(i))
+			if options.keeps(child, false) || holdsKeptFields(child, item, options) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// describeOperations formats at most max operations for an event message
+func describeOperations(operations []patchOperation, max int) string {
+	parts := make([]string, 0, len(operations))
+	for i, operation := range operations {
+		if max > 0 && i == max {
+			parts = append(parts, fmt.Sprintf("and %d more", len(operations)-max))
+			break
+		}
+		switch operation.Op {
+		case "add":
+			parts = append(parts, fmt.Sprintf("%s added %s", operation.Path, describeDiffValue(operation.Value)))
+		case "remove":
+			parts = append(parts, fmt.Sprintf("%s removed (was %s)", operation.Path, describeDiffValue(operation.OldValue)))
+		default:
+			parts = append(parts, fmt.Sprintf("%s %s -> %s", operation.Path, describeDiffValue(operation.OldValue), describeDiffValue(operation.Value)))
+		}
+	}
+	return strings.Join(parts, "; ")
+}
+
+// describeDiffValue formats a value compactly: strings as they are, anything else
+// as JSON, truncated
+func describeDiffValue(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return truncate(s, diffValueLength)
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return truncate(string(encoded), diffValueLength)
+}
+
+// redactOperations masks sensitive data in the string values of a diff, copying
+// the operations it changes
+func redactOperations(r *redactor, operations []patchOperation) []patchOperation {
+	redacted := make([]patchOperation, len(operations))
+	for i, operation := range operations {
+		operation.Value = redactValue(r, operation.Value)
+		operation.OldValue = redactValue(r, operation.OldValue)
+		redacted[i] = operation
+	}
+	return redacted
+}
+
+// redactValue masks sensitive data in the strings of a JSON value
+func redactValue(r *redactor, value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		return r.redact(v)
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			copied[key] = redactValue(r, item)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(v))
+		for i, item := range v {
+			copied[i] = redactValue(r, item)
+		}
+		return copied
+	}
+	return value
+}
+
+// eventDiff decodes the diff carried by a synthetic event, if any
+func eventDiff(event *v1.Event) []patchOperation {
+	encoded, ok := event.Annotations[diffAnnotation]
+	if !ok {
+		return nil
+	}
+	var operations []patchOperation
+	if err := json.Unmarshal([]byte(encoded), &operations); err != nil {
+		return nil
+	}
+	return operations
+}
+
+// objectKind returns the kind of an informer object: the kind of unstructured
+// objects, the Go type name of typed ones, whose kind is not kept in caches
+func objectKind(obj interface{}) string {
+	if u, ok := obj.(*unstructured.Unstructured); ok {
+		return u.GetKind()
+	}
+	t := reflect.TypeOf(obj)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Name()
+}
+
+// diffHandler returns the informer handler emitting the diff of every updated
+// object of a watched namespace. Objects watched by several informers are diffed
+// once per version.
+func (w *clusterWatcher) diffHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(old, obj interface{}) {
+			oldObject, ok1 := old.(metav1.Object)
+			object, ok2 := obj.(metav1.Object)
+			if !ok1 || !ok2 || oldObject.GetResourceVersion() == object.GetResourceVersion() || !w.watches(object.GetNamespace()) {
+				return
+			}
+
+			w.mu.Lock()
+			seen := w.diffed[object.GetUID()] == object.GetResourceVersion()
+			w.diffed[object.GetUID()] = object.GetResourceVersion()
+			w.mu.Unlock()
+			if seen {
+				return
+			}
+
+			kind := objectKind(obj)
+			operations, err := diffObjects(old, obj, diffOptionsFor(cfg.Diffs, kind))
+			if err != nil {
+				log.WithFields(logrus.Fields{"cluster": w.name, "kind": kind, "error": err}).Debug("Failed to diff object")
+				return
+			}
+			if len(operations) == 0 {
+				return
+			}
+			encoded, err := json.Marshal(operations)
+			if err != nil {
+				return
+			}
+
+			event := syntheticEvent(kind, object, "Updated", v1.EventTypeNormal, fmt.Sprintf("%s %s updated: %s",
+				strings.ToLower(kind), object.GetName(), describeOperations(operations, cfg.Diffs.MaxOperations)))
+			event.Annotations = map[string]string{diffAnnotation: string(encoded)}
+			w.emitAs(watch.Modified, event)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if object, ok := obj.(metav1.Object); ok {
+				w.mu.Lock()
+				delete(w.diffed, object.GetUID())
+				w.mu.Unlock()
+			}
+		},
+	}
+}
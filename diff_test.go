@@ -0,0 +1,121 @@
+package main
+
+import (
+	"testing" // Test framework
+
+	appsv1 "k8s.io/api/apps/v1"                   // Apps v1 API for Kubernetes
+	v1 "k8s.io/api/core/v1"                       // Core v1 API for Kubernetes
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1" // Meta v1 API for Kubernetes
+)
+
+func TestMatchesPathPrefix(t *testing.T) {
+	tests := []struct {
+		pattern string // Configured path
+		path    string // Changed field
+		want    bool   // Whether the pattern covers the field
+	}{
+		{"/", "/spec/replicas", true},
+		{"/spec", "/spec/replicas", true},
+		{"/spec/replicas", "/spec/replicas", true},
+		{"/spec/replicas", "/spec", false},
+		{"/spec", "/status/replicas", false},
+		{"/spec/*/image", "/spec/template/image", true},
+		{"/spec/*/image", "/spec/template/spec/image", false},
+		{"/**/image", "/image", true},
+		{"/**/image", "/spec/template/spec/containers/0/image", true},
+		{"/**/image", "/spec/template/spec/containers/0/imagePullPolicy", false},
+		{"/**/lastTransitionTime", "/status/conditions/2/lastTransitionTime", true},
+		{"/spec/**/image", "/status/containerStatuses/0/image", false},
+		{"/status/**", "/status/phase", true},
+		{"/metadata/annotations/kubectl.kubernetes.io~1last-applied-configuration",
+			"/metadata/annotations/kubectl.kubernetes.io~1last-applied-configuration", true},
+		{"/metadata/annotations/kubectl.kubernetes.io~1last-applied-configuration", "/metadata/annotations/kubectl.kubernetes.io", false},
+	}
+	for _, tt := range tests {
+		if got := matchesPathPrefix(splitPointer(tt.pattern), splitPointer(tt.path)); got != tt.want {
+			t.Errorf("matchesPathPrefix(%s, %s) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestDiffOptionsKeeps(t *testing.T) {
+	config := DiffConfig{
+		Ignore: []string{"/metadata/labels/pod-template-hash"},
+		Kinds: map[string]KindDiffConfig{
+			"Deployment": {Include: []string{"/spec", "/metadata/labels"}, Ignore: []string{"/spec/template/metadata/annotations"}},
+			"Pod":        {Ignore: []string{"/status"}},
+			"Node":       {Ignore: []string{"/"}},
+		},
+	}
+	tests := []struct {
+		kind string // Kind of the object
+		path string // Changed field
+		want bool   // Whether the change is diffed
+	}{
+		{"Deployment", "/spec/replicas", true},
+		{"Deployment", "/metadata/labels/app", true},
+		{"Deployment", "/status/replicas", false},
+		{"Deployment", "/metadata/annotations/owner", false},
+		// Ignored paths win over included ones
+		{"Deployment", "/spec/template/metadata/annotations/restartedAt", false},
+		{"Deployment", "/metadata/labels/pod-template-hash", false},
+		{"Deployment", "/spec/template/spec/containers/0/lastTransitionTime", false},
+		{"Pod", "/spec/containers/0/image", true},
+		{"Pod", "/status/phase", false},
+		{"Pod", "/metadata/managedFields/0/time", false},
+		{"Node", "/spec/unschedulable", false},
+		{"Service", "/spec/selector/app", true},
+		{"Service", "/metadata/resourceVersion", false},
+		{"Secret", "/data/password", false},
+		{"Secret", "/stringData/password", false},
+		{"Secret", "/metadata/labels/app", true},
+		{"ConfigMap", "/data/settings.yaml", false},
+		{"ConfigMap", "/binaryData/logo.png", false},
+		{"ConfigMap", "/metadata/labels/app", true},
+	}
+	for _, tt := range tests {
+		if got := diffOptionsFor(config, tt.kind).keeps(splitPointer(tt.path), false); got != tt.want {
+			t.Errorf("%s %s kept = %v, want %v", tt.kind, tt.path, got, tt.want)
+		}
+	}
+
+	// Parents of included fields are looked into, not reported
+	options := diffOptionsFor(config, "Deployment")
+	if !options.keeps(nil, true) || !options.keeps(splitPointer("/metadata"), true) || options.keeps(splitPointer("/metadata"), false) {
+		t.Error("parents of included fields not handled as parents")
+	}
+}
+
+func TestDiffObjects(t *testing.T) {
+	replicas := func(n int32) *int32 { return &n }
+	old := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "api", ResourceVersion: "1", Labels: map[string]string{"app": "api"}},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: replicas(2),
+			Template: v1.PodTemplateSpec{Spec: v1.PodSpec{Containers: []v1.Container{{Name: "api", Image: "api:v1"}}}},
+		},
+	}
+	updated := old.DeepCopy()
+	updated.ResourceVersion = "2"
+	updated.Labels["team"] = "payments"
+	updated.Spec.Template.Spec.Containers[0].Image = "api:v2"
+	updated.Status.ReadyReplicas = 1
+
+	config := DiffConfig{Kinds: map[string]KindDiffConfig{"Deployment": {Ignore: []string{"/status"}}}}
+	operations, err := diffObjects(old, updated, diffOptionsFor(config, "Deployment"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []patchOperation{
+		{Op: "add", Path: "/metadata/labels/team", Value: "payments"},
+		{Op: "replace", Path: "/spec/template/spec/containers/0/image", Value: "api:v2", OldValue: "api:v1"},
+	}
+	if len(operations) != len(want) {
+		t.Fatalf("operations %+v, want %+v", operations, want)
+	}
+	for i := range want {
+		if operations[i] != want[i] {
+			t.Errorf("operation %d is %+v, want %+v", i, operations[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json" // For JSON encoding
+	"fmt"           // Response formatting
+	"net/http"      // HTTP server functionalities
+	"strings"       // String building
+	"time"          // For time-related operations
+)
+
+// serverStatus is the document served on /debug/status
+type serverStatus struct {
+	Mode       string           `json:"mode"`       // "watch" or "replay"
+	Clients    int              `json:"clients"`    // Connected WebSocket clients
+	Clusters   []clusterHealth  `json:"clusters"`   // State of every watched cluster
+	Redactions map[string]int64 `json:"redactions"` // Matches masked by each redaction rule
+}
+
+// registerHealthEndpoints registers the liveness, readiness and status endpoints.
+// status returns the mode and client count of the server.
+func registerHealthEndpoints(watchers []*clusterWatcher, status func() serverStatus) {
+	http.HandleFunc("/healthz", handleHealthz)
+	http.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		handleReadyz(w, r, watchers, cfg.Health.WatchFailureThreshold.Duration, cfg.Health.RequireAllClusters)
+	})
+	http.HandleFunc("/debug/status", func(w http.ResponseWriter, r *http.Request) {
+		handleStatus(w, r, status(), watchers)
+	})
+}
+
+// handleHealthz reports that the process is alive
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintln(w, "ok")
+}
+
+// handleReadyz reports the state of every cluster: synced its events and not
+// failing to watch for longer than threshold. The server is ready while one
+// cluster is, so that an unreachable cluster does not take the others out of
+// service, or only when all are with requireAll. Clusters that should not gate
+// readiness can be skipped with ?exclude=name.
+func handleReadyz(w http.ResponseWriter, r *http.Request, watchers []*clusterWatcher, threshold time.Duration, requireAll bool) {
+	excluded := parseFilterValues(r.URL.Query()["exclude"])
+
+	var body strings.Builder
+	healthy, unhealthy := 0, 0
+	for _, watcher := range watchers {
+		health := watcher.status()
+		switch {
+		case excluded[health.Name]:
+			fmt.Fprintf(&body, "[+]%s excluded: ok\n", health.Name)
+		case !health.Synced:
+			unhealthy++
+			fmt.Fprintf(&body, "[-]%s: events not synced\n", health.Name)
+		case !health.FailingSince.IsZero() && time.Since(health.FailingSince) > threshold:
+			unhealthy++
+			fmt.Fprintf(&body, "[-]%s: watch failing for %s: %s\n", health.Name,
+				time.Since(health.FailingSince).Round(time.Second), health.LastError)
+		default:
+			healthy++
+			fmt.Fprintf(&body, "[+]%s ok\n", health.Name)
+		}
+	}
+
+	if ready := unhealthy == 0 || (!requireAll && healthy > 0); !ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		body.WriteString("readyz check failed\n")
+	} else {
+		body.WriteString("ok\n")
+	}
+	fmt.Fprint(w, body.String())
+}
+
+// handleStatus serves the state of every watcher and the number of connected clients
+func handleStatus(w http.ResponseWriter, r *http.Request, status serverStatus, watchers []*clusterWatcher) {
+	status.Clusters = []clusterHealth{}
+	status.Redactions = redaction.counts()
+	for _, watcher := range watchers {
+		status.Clusters = append(status.Clusters, watcher.status())
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(status)
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"fmt"     // Message formatting
+	"strings" // String manipulation
+	"sync"    // Mutual exclusion
+	"time"    // For time-related operations
+
+	autoscalingv2 "k8s.io/api/autoscaling/v2" // Autoscaling v2 API for Kubernetes
+	v1 "k8s.io/api/core/v1"                   // Core v1 API for Kubernetes
+	"k8s.io/apimachinery/pkg/fields"          // For selecting Kubernetes fields
+	"k8s.io/client-go/kubernetes"             // Kubernetes client
+	"k8s.io/client-go/tools/cache"            // For caching Kubernetes objects
+)
+
+// HPA conditions reported by the tracker, with the reasons of the events emitted
+// when the condition turns false and true
+var hpaConditionReasons = map[autoscalingv2.HorizontalPodAutoscalerConditionType][2]string{
+	autoscalingv2.AbleToScale:    {"HPAUnableToScale", "HPAAbleToScale"},
+	autoscalingv2.ScalingActive:  {"HPAScalingInactive", "HPAScalingActive"},
+	autoscalingv2.ScalingLimited: {"HPAScalingWithinRange", "HPAScalingLimited"},
+}
+
+// hpaTracker reports the scaling decisions of HorizontalPodAutoscalers as events,
+// with the metric values behind them, and flags autoscalers pinned at their maximum
+type hpaTracker struct {
+	emit func(*v1.Event) // Receiver of the synthetic events
+
+	mu          sync.Mutex           // Guards pinnedSince
+	pinnedSince map[string]time.Time // Time autoscalers were seen pinned at their maximum, by UID
+}
+
+// newHPATracker creates an HPA tracker
+func newHPATracker() objectTracker {
+	return &hpaTracker{pinnedSince: map[string]time.Time{}}
+}
+
+// start runs an HPA informer for every watched namespace
+func (t *hpaTracker) start(w *clusterWatcher, clientset kubernetes.Interface, stop <-chan struct{}) []cache.InformerSynced {
+	t.emit = w.emit
+	var synced []cache.InformerSynced
+	for _, namespace := range w.namespaces {
+		lw := cache.NewListWatchFromClient(clientset.AutoscalingV2().RESTClient(), "horizontalpodautoscalers", namespace, fields.Everything())
+		nw := w.sharedInformer("horizontalpodautoscalers", namespace, lw, &autoscalingv2.HorizontalPodAutoscaler{}, 0, cache.ResourceEventHandlerDetailedFuncs{
+			AddFunc: func(obj interface{}, isInInitialList bool) {
+				if hpa, ok := obj.(*autoscalingv2.HorizontalPodAutoscaler); ok {
+					t.checkPinned(hpa, !isInInitialList)
+				}
+			},
+			UpdateFunc: func(old, obj interface{}) {
+				oldHPA, ok1 := old.(*autoscalingv2.HorizontalPodAutoscaler)
+				hpa, ok2 := obj.(*autoscalingv2.HorizontalPodAutoscaler)
+				if ok1 && ok2 && oldHPA.ResourceVersion != hpa.ResourceVersion {
+					t.update(oldHPA, hpa)
+				}
+			},
+			DeleteFunc: func(obj interface{}) {
+				if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+					obj = tombstone.Obj
+				}
+				if hpa, ok := obj.(*autoscalingv2.HorizontalPodAutoscaler); ok {
+					t.mu.Lock()
+					delete(t.pinnedSince, string(hpa.UID))
+					t.mu.Unlock()
+				}
+			},
+		}, true)
+		synced = append(synced, nw.informer.HasSynced)
+	}
+	return synced
+}
+
+// update reports the differences between two versions of an autoscaler status
+func (t *hpaTracker) update(old, hpa *autoscalingv2.HorizontalPodAutoscaler) {
+	t.diffReplicas(old, hpa)
+	t.diffConditions(old, hpa)
+	t.checkPinned(hpa, true)
+}
+
+// diffReplicas reports new desired replica counts, with the metrics that led to
+// them, and the target reaching a new replica count
+func (t *hpaTracker) diffReplicas(old, hpa *autoscalingv2.HorizontalPodAutoscaler) {
+	target := describeScaleTarget(hpa)
+
+	if from, to := old.Status.DesiredReplicas, hpa.Status.DesiredReplicas; from != to && to != 0 {
+		reason := "HPAScaleUp"
+		if to < from {
+			reason = "HPAScaleDown"
+		}
+		message := fmt.Sprintf("hpa %s scaling %s from %d to %d replicas (min %d, max %d)",
+			hpa.Name, target, from, to, minReplicas(hpa), hpa.Spec.MaxReplicas)
+		if metrics := describeMetrics(hpa.Spec.Metrics, hpa.Status.CurrentMetrics, old.Status.CurrentMetrics); len(metrics) > 0 {
+			message += ": " + strings.Join(metrics, ", ")
+		}
+		if limited := hpaCondition(hpa, autoscalingv2.ScalingLimited); limited != nil && limited.Status == v1.ConditionTrue {
+			message += "; limited: " + limited.Reason
+		}
+		t.emitFor(hpa, reason, v1.EventTypeNormal, message)
+	}
+
+	if from, to := old.Status.CurrentReplicas, hpa.Status.CurrentReplicas; from != to {
+		t.emitFor(hpa, "HPAReplicasChanged", v1.EventTypeNormal, fmt.Sprintf("hpa %s: %s went from %d to %d replicas (desired %d)",
+			hpa.Name, target, from, to, hpa.Status.DesiredReplicas))
+	}
+}
+
+// diffConditions reports the conditions of an autoscaler changing status
+func (t *hpaTracker) diffConditions(old, hpa *autoscalingv2.HorizontalPodAutoscaler) {
+	for _, condition := range hpa.Status.Conditions {
+		reasons, tracked := hpaConditionReasons[condition.Type]
+		prev := hpaCondition(old, condition.Type)
+		if !tracked || prev == nil || prev.Status == condition.Status || condition.Status == v1.ConditionUnknown {
+			continue
+		}
+
+		reason, severity := reasons[1], v1.EventTypeNormal
+		if condition.Status == v1.ConditionFalse {
+			reason = reasons[0]
+		}
+		// Being unable to scale, inactive or limited needs attention
+		if reason == "HPAUnableToScale" || reason == "HPAScalingInactive" || reason == "HPAScalingLimited" {
+			severity = v1.EventTypeWarning
+		}
+		t.emitFor(hpa, reason, severity, fmt.Sprintf("hpa %s %s is %s: %s: %s",
+			hpa.Name, condition.Type, condition.Status, condition.Reason, condition.Message))
+	}
+}
+
+// checkPinned reports an autoscaler whose target runs at the maximum replica count
+// while the metrics ask for at least as many, and when it is released. Autoscalers
+// listed at startup are only a baseline: their state is recorded without report,
+// with an unknown start when pinned.
+func (t *hpaTracker) checkPinned(hpa *autoscalingv2.HorizontalPodAutoscaler, report bool) {
+	key := string(hpa.UID)
+	max := hpa.Spec.MaxReplicas
+	pinned := max > 0 && hpa.Status.CurrentReplicas >= max && hpa.Status.DesiredReplicas >= max
+
+	now := time.Now()
+	start := now
+	if !report {
+		start = time.Time{}
+	}
+	t.mu.Lock()
+	since, wasPinned := t.pinnedSince[key]
+	if pinned && !wasPinned {
+		t.pinnedSince[key] = start
+	} else if !pinned && wasPinned {
+		delete(t.pinnedSince, key)
+	}
+	t.mu.Unlock()
+
+	switch {
+	case !report:
+	case pinned && !wasPinned:
+		message := fmt.Sprintf("hpa %s pinned at maxReplicas %d for %s", hpa.Name, max, describeScaleTarget(hpa))
+		if metrics := describeMetrics(hpa.Spec.Metrics, hpa.Status.CurrentMetrics, nil); len(metrics) > 0 {
+			message += ": " + strings.Join(metrics, ", ")
+		}
+		t.emitFor(hpa, "HPAPinnedAtMax", v1.EventTypeWarning, message)
+	case !pinned && wasPinned:
+		t.emitFor(hpa, "HPAReleasedFromMax", v1.EventTypeNormal, fmt.Sprintf("hpa %s no longer at maxReplicas %d: pinned from %s",
+			hpa.Name, max, describePeriod(since, now)))
+	}
+}
+
+// describeMetrics formats the current value of every metric of an autoscaler with
+// its target and, when known and different, its previous value
+func describeMetrics(specs []autoscalingv2.MetricSpec, current, previous []autoscalingv2.MetricStatus) []string {
+	targets := map[string]autoscalingv2.MetricTarget{}
+	for _, spec := range specs {
+		if name, target, ok := metricSpecTarget(spec); ok {
+			targets[name] = target
+		}
+	}
+	before := map[string]string{}
+	for _, status := range previous {
+		if name, value, ok := metricStatusValue(status); ok {
+			before[name] = describeMetricValue(value)
+		}
+	}
+
+	var metrics []string
+	for _, status := range current {
+		name, value, ok := metricStatusValue(status)
+		if !ok {
+			continue
+		}
+		metric := name + " " + describeMetricValue(value)
+		var details []string
+		if target, ok := targets[name]; ok {
+			details = append(details, "target "+describeMetricTarget(target))
+		}
+		if was, ok := before[name]; ok && was != describeMetricValue(value) {
+			details = append(details, "was "+was)
+		}
+		if len(details) > 0 {
+			metric += " (" + strings.Join(details, ", ") + ")"
+		}
+		metrics = append(metrics, metric)
+	}
+	return metrics
+}
+
+// metricSpecTarget returns the name and target of a metric of an autoscaler spec
+func metricSpecTarget(spec autoscalingv2.MetricSpec) (string, autoscalingv2.MetricTarget, bool) {
+	switch {
+	case spec.Resource != nil:
+		return string(spec.Resource.Name), spec.Resource.Target, true
+	case spec.ContainerResource != nil:
+		return string(spec.ContainerResource.Name) + " of container " + spec.ContainerResource.Container, spec.ContainerResource.Target, true
+	case spec.Pods != nil:
+		return spec.Pods.Metric.Name + " per pod", spec.Pods.Target, true
+	case spec.Object != nil:
+		return spec.Object.Metric.Name + " of " + describeObjectReference(spec.Object.DescribedObject), spec.Object.Target, true
+	case spec.External != nil:
+		return spec.External.Metric.Name, spec.External.Target, true
+	}
+	return "", autoscalingv2.MetricTarget{}, false
+}
+
+// metricStatusValue returns the name and current value of a metric of an autoscaler
+// status, named like metricSpecTarget names the matching spec
+func metricStatusValue(status autoscalingv2.MetricStatus) (string, autoscalingv2.MetricValueStatus, bool) {
+	switch {
+	case status.Resource != nil:
+		return string(status.Resource.Name), status.Resource.Current, true
+	case status.ContainerResource != nil:
+		return string(status.ContainerResource.Name) + " of container " + status.ContainerResource.Container, status.ContainerResource.Current, true
+	case status.Pods != nil:
+		return status.Pods.Metric.Name + " per pod", status.Pods.Current, true
+	case status.Object != nil:
+		return status.Object.Metric.Name + " of " + describeObjectReference(status.Object.DescribedObject), status.Object.Current, true
+	case status.External != nil:
+		return status.External.Metric.Name, status.External.Current, true
+	}
+	return "", autoscalingv2.MetricValueStatus{}, false
+}
+
+// describeMetricValue formats the current value of a metric
+func describeMetricValue(value autoscalingv2.MetricValueStatus) string {
+	switch {
+	case value.AverageUtilization != nil:
+		return fmt.Sprintf("%d%%", *value.AverageUtilization)
+	case value.AverageValue != nil:
+		return value.AverageValue.String() + " average"
+	case value.Value != nil:
+		return value.Value.String()
+	}
+	return "<unknown>"
+}
+
+// describeMetricTarget formats the target of a metric
+func describeMetricTarget(target autoscalingv2.MetricTarget) string {
+	switch {
+	case target.Type == autoscalingv2.UtilizationMetricType && target.AverageUtilization != nil:
+		return fmt.Sprintf("%d%%", *target.AverageUtilization)
+	case target.Type == autoscalingv2.AverageValueMetricType && target.AverageValue != nil:
+		return target.AverageValue.String() + " average"
+	case target.Value != nil:
+		return target.Value.String()
+	}
+	return "<unknown>"
+}
+
+// describeObjectReference formats a reference like kubectl does
+func describeObjectReference(ref autoscalingv2.CrossVersionObjectReference) string {
+	return strings.ToLower(ref.Kind) + "/" + ref.Name
+}
+
+// describeScaleTarget formats the object an autoscaler scales
+func describeScaleTarget(hpa *autoscalingv2.HorizontalPodAutoscaler) string {
+	return describeObjectReference(hpa.Spec.ScaleTargetRef)
+}
+
+// minReplicas returns the minimum replica count of an autoscaler, 1 when unset
+func minReplicas(hpa *autoscalingv2.HorizontalPodAutoscaler) int32 {
+	if hpa.Spec.MinReplicas != nil {
+		return *hpa.Spec.MinReplicas
+	}
+	return 1
+}
+
+// hpaCondition returns the condition of an autoscaler with the given type, if any
+func hpaCondition(hpa *autoscalingv2.HorizontalPodAutoscaler, conditionType autoscalingv2.HorizontalPodAutoscalerConditionType) *autoscalingv2.HorizontalPodAutoscalerCondition {
+	for i := range hpa.Status.Conditions {
+		if hpa.Status.Conditions[i].Type == conditionType {
+			return &hpa.Status.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// emitFor emits an event about an autoscaler
+func (t *hpaTracker) emitFor(hpa *autoscalingv2.HorizontalPodAutoscaler, reason, severity, message string) {
+	t.emit(syntheticEvent("HorizontalPodAutoscaler", hpa, reason, severity, message))
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json" // For JSON encoding
+	"sync"          // Mutual exclusion
+
+	"github.com/sirupsen/logrus" // Package for structured logging
+)
+
+// Number of events buffered for a client before new ones are dropped
+const clientQueueSize = 256
+
+// hubClient is a consumer of the events published on a hub
+type hubClient struct {
+	sub     subscription // Filters requested by the client
+	user    *userInfo    // Authenticated user, nil when authentication is disabled
+	events  chan Event   // Events waiting to be sent to the client
+	dropped int          // Events dropped because the client was too slow
+}
+
+// hub fans translated events out to every connected client
+type hub struct {
+	mu      sync.Mutex              // Guards clients
+	clients map[*hubClient]struct{} // Connected clients
+}
+
+// newHub creates an empty hub
+func newHub() *hub {
+	return &hub{clients: map[*hubClient]struct{}{}}
+}
+
+// subscribe registers a client of user receiving the events matching sub
+func (h *hub) subscribe(sub subscription, user *userInfo) *hubClient {
+	client := &hubClient{sub: sub, user: user, events: make(chan Event, clientQueueSize)}
+	log.WithFields(client.fields()).Info("WebSocket client connected")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.clients[client] = struct{}{}
+	return client
+}
+
+// unsubscribe removes a client from the hub
+func (h *hub) unsubscribe(client *hubClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.clients, client)
+
+	if client.dropped > 0 {
+		log.WithFields(client.fields()).WithField("dropped", client.dropped).Warning("Slow WebSocket client dropped events")
+	}
+	log.WithFields(client.fields()).Info("WebSocket client disconnected")
+}
+
+// fields returns the log fields identifying a client
+func (c *hubClient) fields() logrus.Fields {
+	if c.user == nil {
+		return logrus.Fields{}
+	}
+	return c.user.fields()
+}
+
+// clientCount returns the number of connected clients
+func (h *hub) clientCount() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.clients)
+}
+
+// publish sends an event to every client whose subscription matches it,
+// dropping it for clients whose queue is full
+func (h *hub) publish(event Event) {
+	// Logging the event
+	if cfg.outputEnabled(outputLog) {
+		logEvent(event)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for client := range h.clients {
+		if !client.sub.matches(event) {
+			continue
+		}
+		select {
+		case client.events <- event:
+		default:
+			client.dropped++
+		}
+	}
+}
+
+// logEvent logs an event streamed to clients
+func logEvent(event Event) {
+	jsonEvent, err := json.Marshal(event)
+	if err != nil {
+		log.WithFields(logrus.Fields{"error": err}).Error("Failed to marshal event")
+		return
+	}
+	log.WithField("event", string(jsonEvent)).Info("New Kubernetes Event")
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context" // Request contexts for the API
+	"fmt"     // Message formatting
+	"strings" // String manipulation
+	"sync"    // Mutual exclusion
+	"time"    // For time-related operations
+
+	"github.com/sirupsen/logrus"                  // Package for structured logging
+	batchv1 "k8s.io/api/batch/v1"                 // Batch v1 API for Kubernetes
+	v1 "k8s.io/api/core/v1"                       // Core v1 API for Kubernetes
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1" // Meta v1 API for Kubernetes
+	"k8s.io/apimachinery/pkg/fields"              // For selecting Kubernetes fields
+	"k8s.io/client-go/kubernetes"                 // Kubernetes client
+	"k8s.io/client-go/tools/cache"                // For caching Kubernetes objects
+)
+
+// Interval at which CronJobs are checked for missed schedules and long suspensions
+const cronJobResyncPeriod = time.Minute
+
+// Maximum number of failed pods described in a job failure
+const maxFailedPods = 5
+
+// jobTracker reports Job outcomes and CronJob scheduling problems as events
+type jobTracker struct {
+	emit      func(*v1.Event)      // Receiver of the synthetic events
+	clientset kubernetes.Interface // Client listing the pods of failed jobs
+	config    JobWatcherConfig     // Thresholds
+
+	mu              sync.Mutex           // Guards the fields below
+	missedReported  map[string]time.Time // Last missed schedule reported by CronJob UID
+	suspendedSince  map[string]time.Time // Time CronJobs were first seen suspended by UID
+	suspendReported map[string]bool      // CronJobs reported as suspended too long by UID
+}
+
+// newJobTracker creates a job tracker using the jobs settings
+func newJobTracker() objectTracker {
+	return &jobTracker{
+		config:          cfg.Jobs,
+		missedReported:  map[string]time.Time{},
+		suspendedSince:  map[string]time.Time{},
+		suspendReported: map[string]bool{},
+	}
+}
+
+// start runs Job and CronJob informers for every watched namespace
+func (t *jobTracker) start(w *clusterWatcher, clientset kubernetes.Interface, stop <-chan struct{}) []cache.InformerSynced {
+	t.emit, t.clientset = w.emit, clientset
+	restClient := clientset.BatchV1().RESTClient()
+
+	var synced []cache.InformerSynced
+	for _, namespace := range w.namespaces {
+		jobs := w.sharedInformer("jobs", namespace,
+			cache.NewListWatchFromClient(restClient, "jobs", namespace, fields.Everything()),
+			&batchv1.Job{}, 0, cache.ResourceEventHandlerDetailedFuncs{
+				AddFunc: func(obj interface{}, isInInitialList bool) {
+					if job, ok := obj.(*batchv1.Job); ok && !isInInitialList {
+						t.updateJob(&batchv1.Job{}, job)
+					}
+				},
+				UpdateFunc: func(old, obj interface{}) {
+					oldJob, ok1 := old.(*batchv1.Job)
+					job, ok2 := obj.(*batchv1.Job)
+					if ok1 && ok2 && oldJob.ResourceVersion != job.ResourceVersion {
+						t.updateJob(oldJob, job)
+					}
+				},
+			}, true)
+
+		cronJobs := w.sharedInformer("cronjobs", namespace,
+			cache.NewListWatchFromClient(restClient, "cronjobs", namespace, fields.Everything()),
+			&batchv1.CronJob{}, cronJobResyncPeriod, cache.ResourceEventHandlerFuncs{
+				AddFunc: func(obj interface{}) {
+					if cronJob, ok := obj.(*batchv1.CronJob); ok {
+						t.checkCronJob(cronJob)
+					}
+				},
+				UpdateFunc: func(_, obj interface{}) {
+					if cronJob, ok := obj.(*batchv1.CronJob); ok {
+						t.checkCronJob(cronJob)
+					}
+				},
+				DeleteFunc: func(obj interface{}) {
+					if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+						obj = tombstone.Obj
+					}
+					if cronJob, ok := obj.(*batchv1.CronJob); ok {
+						t.forgetCronJob(string(cronJob.UID))
+					}
+				},
+			}, true)
+		synced = append(synced, jobs.informer.HasSynced, cronJobs.informer.HasSynced)
+	}
+	return synced
+}
+
+// updateJob reports a job starting, succeeding or failing
+func (t *jobTracker) updateJob(old, job *batchv1.Job) {
+	if old.Status.StartTime == nil && job.Status.StartTime != nil {
+		t.emitFor(job, "JobStarted", v1.EventTypeNormal, fmt.Sprintf("%s started", describeJob(job)))
+	}
+
+	if condition := jobCondition(job, batchv1.JobComplete); condition != nil && jobCondition(old, batchv1.JobComplete) == nil {
+		message := fmt.Sprintf("%s succeeded", describeJob(job))
+		if job.Status.StartTime != nil && job.Status.CompletionTime != nil {
+			message += " in " + job.Status.CompletionTime.Sub(job.Status.StartTime.Time).Round(time.Second).String()
+		}
+		t.emitFor(job, "JobSucceeded", v1.EventTypeNormal, fmt.Sprintf("%s (%d succeeded pods)", message, job.Status.Succeeded))
+	}
+
+	if condition := jobCondition(job, batchv1.JobFailed); condition != nil && jobCondition(old, batchv1.JobFailed) == nil {
+		// Listing the pods must not block the informer
+		go t.reportFailure(job.DeepCopy(), condition.Reason, condition.Message)
+	}
+}
+
+// reportFailure emits the failure of a job with the exit codes of its failed pods
+func (t *jobTracker) reportFailure(job *batchv1.Job, reason, detail string) {
+	message := fmt.Sprintf("%s failed: %s", describeJob(job), reason)
+	if detail != "" {
+		message += " (" + detail + ")"
+	}
+	if exits := t.failedPods(job); len(exits) > 0 {
+		message += "; " + strings.Join(exits, "; ")
+	}
+	t.emitFor(job, "JobFailed", v1.EventTypeWarning, message)
+}
+
+// failedPods describes the failed containers of the pods of a job
+func (t *jobTracker) failedPods(job *batchv1.Job) []string {
+	if job.Spec.Selector == nil {
+		return nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(job.Spec.Selector)
+	if err != nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	pods, err := t.clientset.CoreV1().Pods(job.Namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		log.WithFields(logrus.Fields{"job": job.Namespace + "/" + job.Name, "error": err}).Warning("Failed to list pods of failed job")
+		return nil
+	}
+
+	var exits []string
+	for _, pod := range pods.Items {
+		for _, status := range podContainerStatuses(&pod) {
+			terminated := status.State.Terminated
+			if terminated == nil {
+				terminated = status.LastTerminationState.Terminated
+			}
+			if terminated == nil || terminated.ExitCode == 0 {
+				continue
+			}
+			if len(exits) == maxFailedPods {
+				return append(exits, "...")
+			}
+			exits = append(exits, fmt.Sprintf("pod %s container %s: %s", pod.Name, status.Name, describeTermination(terminated)))
+		}
+	}
+	return exits
+}
+
+// podContainerStatuses returns the init and regular container statuses of a pod
+func podContainerStatuses(pod *v1.Pod) []v1.ContainerStatus {
+	statuses := make([]v1.ContainerStatus, 0, len(pod.Status.InitContainerStatuses)+len(pod.Status.ContainerStatuses))
+	statuses = append(statuses, pod.Status.InitContainerStatuses...)
+	return append(statuses, pod.Status.ContainerStatuses...)
+}
+
+// describeJob names a job and the CronJob owning it, if any
+func describeJob(job *batchv1.Job) string {
+	for _, owner := range job.OwnerReferences {
+		if owner.Kind == "CronJob" {
+			return fmt.Sprintf("job %s of cronjob %s", job.Name, owner.Name)
+		}
+	}
+	return "job " + job.Name
+}
+
+// jobCondition returns the true condition of a job with the given type, if any
+func jobCondition(job *batchv1.Job, conditionType batchv1.JobConditionType) *batchv1.JobCondition {
+	for i := range job.Status.Conditions {
+		if condition := &job.Status.Conditions[i]; condition.Type == conditionType && condition.Status == v1.ConditionTrue {
+			return condition
+		}
+	}
+	return nil
+}
+
+// checkCronJob reports a CronJob that missed a scheduled run or has been suspended
+// for too long, once per missed run and suspension
+func (t *jobTracker) checkCronJob(cronJob *batchv1.CronJob) {
+	key := string(cronJob.UID)
+	now := time.Now()
+
+	if cronJob.Spec.Suspend != nil && *cronJob.Spec.Suspend {
+		t.mu.Lock()
+		since, seen := t.suspendedSince[key]
+		if !seen {
+			since = now
+			t.suspendedSince[key] = now
+		}
+		report := !t.suspendReported[key] && now.Sub(since) >= t.config.SuspendedThreshold.Duration
+		if report {
+			t.suspendReported[key] = true
+		}
+		t.mu.Unlock()
+
+		if report {
+			t.emitFor(cronJob, "CronJobSuspendedTooLong", v1.EventTypeWarning, fmt.Sprintf("cronjob %s has been suspended for %s",
+				cronJob.Name, now.Sub(since).Round(time.Minute)))
+		}
+		return
+	}
+	t.mu.Lock()
+	delete(t.suspendedSince, key)
+	delete(t.suspendReported, key)
+	t.mu.Unlock()
+
+	missed, err := missedSchedule(cronJob, now, t.config.MissedScheduleGrace.Duration)
+	if err != nil {
+		log.WithFields(logrus.Fields{"cronjob": cronJob.Namespace + "/" + cronJob.Name, "error": err}).Debug("Cannot check CronJob schedule")
+		return
+	}
+	if missed.IsZero() {
+		return
+	}
+
+	t.mu.Lock()
+	report := !t.missedReported[key].Equal(missed)
+	t.missedReported[key] = missed
+	t.mu.Unlock()
+	if report {
+		last := "never"
+		if cronJob.Status.LastScheduleTime != nil {
+			last = cronJob.Status.LastScheduleTime.Format(time.RFC3339)
+		}
+		t.emitFor(cronJob, "CronJobMissedSchedule", v1.EventTypeWarning, fmt.Sprintf("cronjob %s missed its run scheduled at %s (schedule %q, last scheduled %s)",
+			cronJob.Name, missed.Format(time.RFC3339), cronJob.Spec.Schedule, last))
+	}
+}
+
+// missedSchedule returns the first run of a CronJob after its last schedule time
+// when that run is overdue by more than the grace period (or the job's starting
+// deadline), zero otherwise
+func missedSchedule(cronJob *batchv1.CronJob, now time.Time, grace time.Duration) (time.Time, error) {
+	location := time.Local
+	if cronJob.Spec.TimeZone != nil {
+		loc, err := time.LoadLocation(*cronJob.Spec.TimeZone)
+		if err != nil {
+			return time.Time{}, err
+		}
+		location = loc
+	}
+	schedule, err := parseCron(cronJob.Spec.Schedule, location)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if cronJob.Spec.StartingDeadlineSeconds != nil {
+		grace = time.Duration(*cronJob.Spec.StartingDeadlineSeconds) * time.Second
+	}
+
+	last := cronJob.CreationTimestamp.Time
+	if cronJob.Status.LastScheduleTime != nil {
+		last = cronJob.Status.LastScheduleTime.Time
+	}
+	next := schedule.next(last)
+	if next.IsZero() || now.Before(next.Add(grace)) {
+		return time.Time{}, nil
+	}
+	return next, nil
+}
+
+// forgetCronJob drops the state kept for a deleted CronJob
+func (t *jobTracker) forgetCronJob(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.missedReported, key)
+	delete(t.suspendedSince, key)
+	delete(t.suspendReported, key)
+}
+
+// emitFor emits an event about a Job or CronJob
+func (t *jobTracker) emitFor(obj metav1.Object, reason, severity, message string) {
+	kind := "Job"
+	if _, ok := obj.(*batchv1.CronJob); ok {
+		kind = "CronJob"
+	}
+	t.emit(syntheticEvent(kind, obj, reason, severity, message))
+}
@@ -2,17 +2,21 @@ package main
 
 import (
 	// Importing necessary packages
+	"context"       // Request contexts
 	"encoding/json" // For JSON encoding
+	"flag"          // Command line flag parsing
 	"net/http"      // HTTP server functionalities
 	"os"            // Interface to operating system functionality
+	"os/signal"     // Receiving shutdown signals
 	"path/filepath" // For manipulating filename paths
-	"time"          // For time-related operations
+	"syscall"       // Signal numbers
 
 	"github.com/gorilla/websocket"                // Package for WebSocket implementations
 	"github.com/sirupsen/logrus"                  // Package for structured logging
 	v1 "k8s.io/api/core/v1"                       // Core v1 API for Kubernetes
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1" // Meta v1 API for Kubernetes
 	"k8s.io/apimachinery/pkg/fields"              // For selecting Kubernetes fields
+	"k8s.io/apimachinery/pkg/watch"               // Watch event types
 	"k8s.io/client-go/kubernetes"                 // Kubernetes client
 	"k8s.io/client-go/rest"                       // RESTful implementation of Kubernetes API
 	"k8s.io/client-go/tools/cache"                // For caching Kubernetes objects
@@ -21,148 +25,412 @@ import (
 
 // Event struct defines the structure for Kubernetes events.
 type Event struct {
-	Type      string `json:"type"`      // Type of the event
-	Object    Object `json:"object"`    // Kubernetes object involved in the event
-	Timestamp string `json:"timestamp"` // Timestamp of the event
+	Type      string          `json:"type"`              // Type of the event
+	Cluster   string          `json:"cluster"`           // Cluster the event comes from
+	Object    Object          `json:"object"`            // Kubernetes object involved in the event
+	Timestamp string          `json:"timestamp"`         // Timestamp of the event
+	Alert     *Alert          `json:"alert,omitempty"`   // Alert of ALERT notifications
+	Storm     *StormSummary   `json:"storm,omitempty"`   // Storm of STORM notifications
+	Anomaly   *AnomalySummary `json:"anomaly,omitempty"` // Anomaly of ANOMALY notifications
 }
 
 // Object struct defines the Kubernetes object involved in the event.
 type Object struct {
-	Kind      string `json:"kind"`      // Type of Kubernetes object
-	Name      string `json:"name"`      // Name of the object
-	Namespace string `json:"namespace"` // Kubernetes namespace
-	Message   string `json:"message"`   // Event message
+	Kind      string           `json:"kind"`           // Type of Kubernetes object
+	Name      string           `json:"name"`           // Name of the object
+	Namespace string           `json:"namespace"`      // Kubernetes namespace
+	Reason    string           `json:"reason"`         // Short machine-readable reason, such as BackOff
+	Severity  string           `json:"severity"`       // Normal or Warning
+	Message   string           `json:"message"`        // Event message
+	Diff      []patchOperation `json:"diff,omitempty"` // Changed fields of updated objects
 }
 
+// Layout used for human-readable event timestamps
+const timestampLayout = "2006-01-02 15:04:05"
+
 // Logger instance for structured logging
 var log = logrus.New()
 
+// Effective configuration, resolved in main
+var cfg = defaultConfig()
+
 // WebSocket upgrader configuration
 var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,                                       // Read buffer size
-	WriteBufferSize: 1024,                                       // Write buffer size
-	CheckOrigin:     func(r *http.Request) bool { return true }, // Allowing all origins
+	ReadBufferSize:  1024,                        // Read buffer size
+	WriteBufferSize: 1024,                        // Write buffer size
+	CheckOrigin:     originAllowed,               // Same origin or allow-listed origins only
+	Subprotocols:    []string{eventsSubprotocol}, // Echoed to clients sending their token as a subprotocol
 }
 
-// handleConnections manages WebSocket connections and streams Kubernetes events
-func handleConnections(w http.ResponseWriter, r *http.Request, clientset *kubernetes.Clientset) {
-	var ws *websocket.Conn
-	var err error
+// upgradeConnection upgrades an HTTP request to a WebSocket connection. On failure
+// the upgrader has already answered the request with an HTTP error.
+func upgradeConnection(w http.ResponseWriter, r *http.Request) (*websocket.Conn, error) {
+	ws, err := upgrader.Upgrade(w, r, nil) // Upgrading HTTP to WebSocket
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"remote": r.RemoteAddr,
+			"error":  err,
+		}).Warning("WebSocket upgrade failed")
+		return nil, err
+	}
+	return ws, nil
+}
 
-	// Retry loop for establishing WebSocket connection
-	for attempt := 0; attempt < 3; attempt++ {
-		ws, err = upgrader.Upgrade(w, r, nil) // Upgrading HTTP to WebSocket
-		if err != nil {
-			// Log the error and retry after a pause
-			log.WithFields(logrus.Fields{
-				"attempt": attempt + 1,
-				"error":   err,
-			}).Warning("WebSocket upgrade failed, retrying...")
-			time.Sleep(time.Second * 5) // 5-second pause
-			continue
-		}
-		break
+// streamed reports whether a change to a Kubernetes event is streamed to clients:
+// new events, and the updates of watched objects carrying a diff. Count bumps of
+// existing events and deletions are not.
+func streamed(eventType watch.EventType, event *v1.Event) bool {
+	_, diff := event.Annotations[diffAnnotation]
+	return eventType == watch.Added || (eventType == watch.Modified && diff)
+}
+
+// translateEvent converts a Kubernetes event of a cluster into the Event streamed to
+// clients, with sensitive data redacted from its message
+func translateEvent(cluster, eventType string, event *v1.Event) Event {
+	return redaction.redactEvent(convertEvent(cluster, eventType, event))
+}
+
+// convertEvent converts a Kubernetes event of a cluster into the Event streamed to
+// clients, without redaction
+func convertEvent(cluster, eventType string, event *v1.Event) Event {
+	// Formatting timestamp to be more human-readable
+	formattedTimestamp := event.FirstTimestamp.Time.Format(timestampLayout)
+
+	return Event{
+		Type:    eventType,
+		Cluster: cluster,
+		Object: Object{
+			Kind:      event.InvolvedObject.Kind,
+			Name:      event.InvolvedObject.Name,
+			Namespace: event.InvolvedObject.Namespace,
+			Reason:    event.Reason,
+			Severity:  event.Type,
+			Message:   event.Message,
+			Diff:      eventDiff(event),
+		},
+		Timestamp: formattedTimestamp,
+	}
+}
+
+// sendEvent marshals an Event and writes it to the WebSocket if the subscription accepts it
+func sendEvent(ws *websocket.Conn, sub subscription, event Event) error {
+	if !sub.matches(event) {
+		return nil
 	}
 
-	// Handle failure after retries
+	// Marshaling event data to JSON
+	jsonEvent, err := json.Marshal(event)
 	if err != nil {
-		log.WithField("error", err).Error("WebSocket upgrade failed after retries")
-		return
+		return err
 	}
 
-	// Close WebSocket connection on function exit
-	defer ws.Close()
+	// Sending event over WebSocket
+	return ws.WriteMessage(websocket.TextMessage, jsonEvent)
+}
 
-	// Setting up Kubernetes event watcher
-	watchList := cache.NewListWatchFromClient(
+// newEventListWatch creates a watcher for Kubernetes events in a namespace
+func newEventListWatch(clientset kubernetes.Interface, namespace string) *cache.ListWatch {
+	return cache.NewListWatchFromClient(
 		clientset.CoreV1().RESTClient(), // REST client for events
 		"events",                        // Watching events
-		metav1.NamespaceAll,             // In all namespaces
+		namespace,                       // In the given namespace (or all)
 		fields.Everything(),             // Selecting all fields
 	)
+}
 
-	// Informer for handling Kubernetes events
-	_, controller := cache.NewInformer(
-		watchList,   // Watch list created above
-		&v1.Event{}, // Watching Kubernetes Event objects
-		0,           // No resync period
-		cache.ResourceEventHandlerFuncs{
-			AddFunc: func(obj interface{}) {
-				event, ok := obj.(*v1.Event) // Casting to *v1.Event
-				if !ok {
-					return
-				}
+// watchClose returns a channel closed when the client closes the WebSocket connection
+func watchClose(ws *websocket.Conn) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			// Reading messages (content ignored) until the connection fails
+			if _, _, err := ws.ReadMessage(); err != nil {
+				log.WithField("error", err).Debug("WebSocket connection closed")
+				return
+			}
+		}
+	}()
+	return done
+}
 
-				// Formatting timestamp to be more human-readable
-				formattedTimestamp := event.FirstTimestamp.Time.Format("2006-01-02 15:04:05")
-
-				// Marshaling event data to JSON
-				jsonEvent, _ := json.Marshal(Event{
-					Type:      "ADDED",
-					Object:    Object{Kind: event.InvolvedObject.Kind, Name: event.InvolvedObject.Name, Namespace: event.InvolvedObject.Namespace, Message: event.Message},
-					Timestamp: formattedTimestamp,
-				})
-
-				// Logging the event
-				log.WithField("event", string(jsonEvent)).Info("New Kubernetes Event")
-				// Sending event over WebSocket
-				ws.WriteMessage(websocket.TextMessage, jsonEvent)
-			},
-		},
-	)
+// handleConnections manages WebSocket connections and streams Kubernetes events
+func handleConnections(w http.ResponseWriter, r *http.Request, events *hub, watchers []*clusterWatcher, authz namespaceAuthorizer) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
 
-	// Channel to signal the stop of the controller
-	stop := make(chan struct{})
-	defer close(stop)       // Ensure channel is closed when exiting
-	go controller.Run(stop) // Running the controller in a separate goroutine
+	// Rejecting cross-site requests before any other work
+	if !checkOrigin(w, r) {
+		return
+	}
+
+	// Subscription filters requested by the client, restricted to the authorized namespaces
+	sub := parseSubscription(r)
+	if !authorizeRequest(ctx, w, r, authz, &sub) {
+		return
+	}
+
+	ws, err := upgradeConnection(w, r)
+	if err != nil {
+		return
+	}
+
+	// Close WebSocket connection on function exit
+	defer ws.Close()
 
-	// Keeping the WebSocket connection alive
+	client := events.subscribe(sub, requestUser(r))
+	defer events.unsubscribe(client)
+	done := watchClose(ws)
+
+	// Sending the events already known to the informers
+	for _, watcher := range watchers {
+		for _, event := range watcher.snapshot() {
+			if err := sendEvent(ws, sub, translateEvent(watcher.name, "ADDED", event)); err != nil {
+				return
+			}
+		}
+	}
+
+	// Streaming new events until the client leaves
 	for {
-		_, _, err := ws.ReadMessage() // Reading message (content ignored)
-		if err != nil {
-			// Log and attempt to re-establish connection
-			log.WithField("error", err).Warning("WebSocket read error, attempting reconnection")
-			handleConnections(w, r, clientset) // Recursive call for reconnection
+		select {
+		case event := <-client.events:
+			if err := sendEvent(ws, sub, event); err != nil {
+				log.WithField("error", err).Warning("WebSocket write error, closing connection")
+				return
+			}
+		case <-done:
 			return
 		}
 	}
 }
 
-func main() {
-	// Logger configuration
-	log.Formatter = &logrus.JSONFormatter{} // JSON formatter for logging
-	log.Level = logrus.InfoLevel            // Setting log level to Info
-
+// newRESTConfig builds the Kubernetes client configuration, using the in-cluster
+// configuration when asked to or when running in a pod without an explicit kubeconfig
+func newRESTConfig(cfg *Config, inCluster bool) (*rest.Config, error) {
 	var config *rest.Config
 	var err error
 
 	// Determining Kubernetes configuration context (in-cluster or external)
-	if _, exists := os.LookupEnv("KUBERNETES_SERVICE_HOST"); exists {
+	if _, exists := os.LookupEnv("KUBERNETES_SERVICE_HOST"); inCluster || (exists && cfg.Kubeconfig == "" && cfg.Context == "") {
 		config, err = rest.InClusterConfig() // In-cluster configuration
 	} else {
-		kubeconfig := filepath.Join(os.Getenv("HOME"), ".kube", "config") // Path to kubeconfig
-		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)      // Building config from kubeconfig
+		rules := clientcmd.NewDefaultClientConfigLoadingRules() // $KUBECONFIG or $HOME/.kube/config
+		if paths := filepath.SplitList(cfg.Kubeconfig); len(paths) == 1 {
+			rules.ExplicitPath = paths[0]
+		} else if len(paths) > 1 {
+			rules.Precedence = paths
+		}
+		overrides := &clientcmd.ConfigOverrides{CurrentContext: cfg.Context}
+		config, err = clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	config.QPS = cfg.Client.QPS
+	config.Burst = cfg.Client.Burst
+	return config, nil
+}
+
+// watchedNamespaces returns the namespaces to watch, NamespaceAll when none are configured
+func watchedNamespaces(cfg *Config) []string {
+	if len(cfg.Namespaces) == 0 {
+		return []string{metav1.NamespaceAll}
+	}
+	return cfg.Namespaces
+}
+
+func main() {
+	// Default logger configuration until the configuration is loaded
+	cfg.configureLogger()
+
+	// Loading configuration from flags, environment and config file
+	var printConfig bool
+	var err error
+	cfg, printConfig, err = loadConfig(os.Args[1:])
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		log.WithField("error", err).Fatal("Invalid configuration")
+	}
+	if printConfig {
+		if err := cfg.print(os.Stdout); err != nil {
+			log.WithField("error", err).Fatal("Failed to print configuration")
+		}
+		return
+	}
+
+	// Logger configuration
+	cfg.configureLogger()
+
+	// Redacting sensitive data from outgoing events
+	redaction, err = newRedactor(cfg.Redaction)
+	if err != nil {
+		log.WithField("error", err).Fatal("Invalid redaction rules")
 	}
 
-	// Handle configuration error
+	// WebSocket buffer configuration
+	upgrader.ReadBufferSize = cfg.WebSocket.ReadBufferSize
+	upgrader.WriteBufferSize = cfg.WebSocket.WriteBufferSize
+
+	// Channel to signal the stop of the watchers
+	stop := make(chan struct{})
+
+	// Restricting clients to their authorized namespaces when configured
+	authz, err := newAuthorizer(&cfg, stop)
 	if err != nil {
-		log.WithField("error", err).Fatal("Failed to configure Kubernetes client")
+		log.WithField("error", err).Fatal("Failed to configure authorization")
+	}
+
+	// Replay mode does not need a cluster
+	if cfg.Replay.File != "" {
+		log.WithFields(logrus.Fields{
+			"file":  cfg.Replay.File,
+			"speed": cfg.Replay.Speed,
+		}).Info("Replay mode enabled")
+
+		// Registering WebSocket endpoint
+		if cfg.outputEnabled(outputWebSocket) {
+			http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+				handleReplay(w, r, cfg.Replay.File, cfg.Replay.Speed, authz) // Replaying recorded events
+			})
+		}
+
+		// Registering health endpoints
+		registerHealthEndpoints(nil, func() serverStatus {
+			return serverStatus{Mode: "replay", Clients: int(replayClients.Load())}
+		})
+	} else {
+		events := newHub()
+
+		// Rolling up event storms instead of streaming their events
+		storms := newStormDetector(cfg.Storms, events.publish)
+		go storms.run(stop)
+
+		// Translating new events and object diffs for the connected clients, counting
+		// redaction hits here where every event is published once
+		counting := redaction.counted()
+		handlers := []eventHandler{func(cluster string, eventType watch.EventType, event *v1.Event) {
+			if streamed(eventType, event) {
+				translated := counting.redactEvent(convertEvent(cluster, string(eventType), event))
+				if storms.admit(translated, occurrenceTime(event)) {
+					events.publish(translated)
+				}
+			}
+		}}
+
+		// Raising alerts from the events, unless silenced
+		silences, err := newSilenceStore(cfg.Silences)
+		if err != nil {
+			log.WithField("error", err).Fatal("Invalid maintenance windows")
+		}
+		alerting, err = newAlertEngine(cfg.Alerts, silences, events.publish)
+		if err != nil {
+			log.WithField("error", err).Fatal("Invalid alert rules")
+		}
+		if len(alerting.rules) > 0 {
+			log.WithField("rules", describeAlertRules(alerting.rules)).Info("Alerting enabled")
+			handlers = append(handlers, alerting.handle)
+			go alerting.run(stop)
+		}
+
+		// Learning event rates and reporting anomalies when enabled
+		var anomalies *anomalyDetector
+		if cfg.Anomalies.Enabled {
+			anomalies = newAnomalyDetector(cfg.Anomalies, events.publish)
+			handlers = append(handlers, anomalies.handle)
+			go anomalies.run(stop)
+		}
+
+		// Recording raw events when requested
+		var rec *recorder
+		if cfg.Record.Dir != "" {
+			rec, err = newRecorder(cfg.Record.Dir, cfg.Record.MaxSizeMB*1024*1024, cfg.Record.MaxAge.Duration)
+			if err != nil {
+				log.WithField("error", err).Fatal("Failed to create recording directory")
+			}
+			log.WithField("dir", cfg.Record.Dir).Info("Recording Kubernetes events")
+			handlers = append(handlers, rec.handle)
+		}
+
+		// Watching every cluster independently
+		watchers := newClusterWatchers(&cfg, handlers...)
+		for _, watcher := range watchers {
+			go watcher.run(stop)
+		}
+
+		// Finishing the archive and saving the anomaly baselines on shutdown
+		if rec != nil || anomalies != nil {
+			signals := make(chan os.Signal, 1)
+			signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+			go func() {
+				<-signals
+				close(stop)
+				if rec != nil {
+					if err := rec.Close(); err != nil {
+						log.WithField("error", err).Error("Failed to close recording")
+					}
+				}
+				if anomalies != nil {
+					anomalies.persist()
+				}
+				os.Exit(0)
+			}()
+		}
+
+		// Registering WebSocket endpoint
+		if cfg.outputEnabled(outputWebSocket) {
+			http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+				handleConnections(w, r, events, watchers, authz) // Handling WebSocket connections
+			})
+		}
+
+		// Registering alert and silence endpoints
+		http.HandleFunc("/api/v1/alerts", func(w http.ResponseWriter, r *http.Request) {
+			handleAlerts(w, r, alerting, authz)
+		})
+		silencesHandler := func(w http.ResponseWriter, r *http.Request) {
+			handleSilences(w, r, silences, authz)
+		}
+		http.HandleFunc("/api/v1/silences", silencesHandler)
+		http.HandleFunc("/api/v1/silences/", silencesHandler)
+
+		// Registering health endpoints
+		registerHealthEndpoints(watchers, func() serverStatus {
+			return serverStatus{Mode: "watch", Clients: events.clientCount()}
+		})
 	}
 
-	// Creating Kubernetes clientset
-	clientset, err := kubernetes.NewForConfig(config)
+	// Requiring authentication when configured
+	var handler http.Handler = http.DefaultServeMux
+	auth, err := newAuthenticator(&cfg)
 	if err != nil {
-		log.WithField("error", err).Fatal("Failed to create Kubernetes client")
+		log.WithField("error", err).Fatal("Failed to configure authentication")
+	}
+	if auth != nil || cfg.TLS.ClientAuth != clientAuthNone {
+		handler = requireAuthentication(auth, handler)
 	}
 
-	// Registering WebSocket endpoint
-	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
-		handleConnections(w, r, clientset) // Handling WebSocket connections
-	})
+	// Serving TLS when a certificate is configured
+	tlsConfig, err := newTLSConfig(&cfg, stop)
+	if err != nil {
+		log.WithField("error", err).Fatal("Failed to configure TLS")
+	}
+	server := &http.Server{Addr: cfg.ListenAddress, Handler: handler, TLSConfig: tlsConfig}
 
-	// Starting WebSocket server
-	log.Info("WebSocket server started on :7008")
-	err = http.ListenAndServe(":7008", nil)
+	// Starting HTTP server
+	log.WithFields(logrus.Fields{
+		"address":    cfg.ListenAddress,
+		"tls":        tlsConfig != nil,
+		"clientAuth": cfg.TLS.ClientAuth,
+	}).Info("WebSocket server started")
+	if tlsConfig != nil {
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		err = server.ListenAndServe()
+	}
 	if err != nil {
 		log.WithField("error", err).Fatal("ListenAndServe failed") // Handling server start error
 	}
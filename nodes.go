@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"     // Message formatting
+	"strings" // String manipulation
+	"sync"    // Mutual exclusion
+	"time"    // For time-related operations
+
+	v1 "k8s.io/api/core/v1"                       // Core v1 API for Kubernetes
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1" // Meta v1 API for Kubernetes
+	"k8s.io/apimachinery/pkg/fields"              // For selecting Kubernetes fields
+	"k8s.io/client-go/kubernetes"                 // Kubernetes client
+	"k8s.io/client-go/tools/cache"                // For caching Kubernetes objects
+)
+
+// Layout of the start and end times in node event messages
+const nodeTimeLayout = time.RFC3339
+
+// Node conditions reported by the tracker, with the status that means trouble
+var nodeProblemStatus = map[v1.NodeConditionType]v1.ConditionStatus{
+	v1.NodeReady:              v1.ConditionFalse,
+	v1.NodeMemoryPressure:     v1.ConditionTrue,
+	v1.NodeDiskPressure:       v1.ConditionTrue,
+	v1.NodePIDPressure:        v1.ConditionTrue,
+	v1.NodeNetworkUnavailable: v1.ConditionTrue,
+}
+
+// Taints the node controller derives from conditions and cordoning, which are
+// already reported through those
+var conditionTaints = map[string]bool{
+	v1.TaintNodeNotReady:           true,
+	v1.TaintNodeUnreachable:        true,
+	v1.TaintNodeUnschedulable:      true,
+	v1.TaintNodeMemoryPressure:     true,
+	v1.TaintNodeDiskPressure:       true,
+	v1.TaintNodePIDPressure:        true,
+	v1.TaintNodeNetworkUnavailable: true,
+}
+
+// nodeTracker reports node conditions, cordoning and taint changes as events,
+// with the number of pods running on the node
+type nodeTracker struct {
+	emit func(*v1.Event)   // Receiver of the synthetic events
+	pods []*namespaceWatch // Pods of every watched namespace
+
+	mu         sync.Mutex           // Guards cordonedAt
+	cordonedAt map[string]time.Time // Time nodes were seen being cordoned, by node name
+}
+
+// newNodeTracker creates a node tracker
+func newNodeTracker() objectTracker {
+	return &nodeTracker{cordonedAt: map[string]time.Time{}}
+}
+
+// start runs the node informer and the pod informers of the watched namespaces,
+// used to count the pods running on each node
+func (t *nodeTracker) start(w *clusterWatcher, clientset kubernetes.Interface, stop <-chan struct{}) []cache.InformerSynced {
+	t.emit = w.emit
+	restClient := clientset.CoreV1().RESTClient()
+
+	var synced []cache.InformerSynced
+	for _, namespace := range w.namespaces {
+		pods := w.sharedInformer("pods", namespace,
+			cache.NewListWatchFromClient(restClient, "pods", namespace, fields.Everything()),
+			&v1.Pod{}, 0, nil, false)
+		t.pods = append(t.pods, pods)
+		synced = append(synced, pods.informer.HasSynced)
+	}
+
+	nodes := w.sharedInformer("nodes", metav1.NamespaceAll,
+		cache.NewListWatchFromClient(restClient, "nodes", metav1.NamespaceAll, fields.Everything()),
+		&v1.Node{}, 0, cache.ResourceEventHandlerFuncs{
+			UpdateFunc: func(old, obj interface{}) {
+				oldNode, ok1 := old.(*v1.Node)
+				node, ok2 := obj.(*v1.Node)
+				if ok1 && ok2 && oldNode.ResourceVersion != node.ResourceVersion {
+					t.update(oldNode, node)
+				}
+			},
+			DeleteFunc: func(obj interface{}) {
+				if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+					obj = tombstone.Obj
+				}
+				if node, ok := obj.(*v1.Node); ok {
+					t.mu.Lock()
+					delete(t.cordonedAt, node.Name)
+					t.mu.Unlock()
+				}
+			},
+		}, true)
+	return append(synced, nodes.informer.HasSynced)
+}
+
+// update reports the differences between two versions of a node
+func (t *nodeTracker) update(old, node *v1.Node) {
+	t.diffConditions(old, node)
+	t.diffCordon(old, node)
+	t.diffTaints(old, node)
+}
+
+// diffConditions reports conditions entering and leaving their problem status
+func (t *nodeTracker) diffConditions(old, node *v1.Node) {
+	previous := map[v1.NodeConditionType]v1.NodeCondition{}
+	for _, condition := range old.Status.Conditions {
+		previous[condition.Type] = condition
+	}
+
+	for _, condition := range node.Status.Conditions {
+		problemStatus, tracked := nodeProblemStatus[condition.Type]
+		prev, known := previous[condition.Type]
+		if !tracked || !known || prev.Status == condition.Status {
+			continue
+		}
+
+		// An unknown Ready condition means the kubelet stopped reporting
+		wasProblem := prev.Status == problemStatus || (condition.Type == v1.NodeReady && prev.Status == v1.ConditionUnknown)
+		isProblem := condition.Status == problemStatus || (condition.Type == v1.NodeReady && condition.Status == v1.ConditionUnknown)
+		start := condition.LastTransitionTime.Time
+
+		switch {
+		case isProblem && !wasProblem:
+			reason := "Node" + string(condition.Type)
+			if condition.Type == v1.NodeReady {
+				reason = "NodeNotReady"
+			}
+			t.emitFor(node, reason, v1.EventTypeWarning, fmt.Sprintf("node %s %s since %s: %s",
+				node.Name, describeCondition(condition), start.Format(nodeTimeLayout), condition.Message))
+		case wasProblem && !isProblem:
+			reason := "Node" + string(condition.Type) + "Resolved"
+			if condition.Type == v1.NodeReady {
+				reason = "NodeReady"
+			}
+			t.emitFor(node, reason, v1.EventTypeNormal, fmt.Sprintf("node %s no longer %s: from %s",
+				node.Name, describeCondition(prev), describePeriod(prev.LastTransitionTime.Time, start)))
+		}
+	}
+}
+
+// describeCondition names the problem a condition reports
+func describeCondition(condition v1.NodeCondition) string {
+	switch {
+	case condition.Type != v1.NodeReady:
+		return "under " + string(condition.Type)
+	case condition.Status == v1.ConditionUnknown:
+		return "NotReady (kubelet stopped posting status)"
+	}
+	return "NotReady"
+}
+
+// describePeriod formats the start, end and duration of a period
+func describePeriod(start, end time.Time) string {
+	if start.IsZero() {
+		return "unknown start to " + end.Format(nodeTimeLayout)
+	}
+	return fmt.Sprintf("%s to %s (%s)", start.Format(nodeTimeLayout), end.Format(nodeTimeLayout), end.Sub(start).Round(time.Second))
+}
+
+// diffCordon reports nodes being cordoned and uncordoned
+func (t *nodeTracker) diffCordon(old, node *v1.Node) {
+	if old.Spec.Unschedulable == node.Spec.Unschedulable {
+		return
+	}
+
+	now := time.Now()
+	t.mu.Lock()
+	cordonedAt := t.cordonedAt[node.Name]
+	if node.Spec.Unschedulable {
+		t.cordonedAt[node.Name] = now
+	} else {
+		delete(t.cordonedAt, node.Name)
+	}
+	t.mu.Unlock()
+
+	if node.Spec.Unschedulable {
+		t.emitFor(node, "NodeCordoned", v1.EventTypeWarning,
+			fmt.Sprintf("node %s cordoned at %s", node.Name, now.Format(nodeTimeLayout)))
+		return
+	}
+	t.emitFor(node, "NodeUncordoned", v1.EventTypeNormal,
+		fmt.Sprintf("node %s uncordoned: cordoned from %s", node.Name, describePeriod(cordonedAt, now)))
+}
+
+// diffTaints reports taints added to and removed from a node
+func (t *nodeTracker) diffTaints(old, node *v1.Node) {
+	previous := map[string]v1.Taint{}
+	for _, taint := range old.Spec.Taints {
+		previous[taint.Key+":"+string(taint.Effect)] = taint
+	}
+	current := map[string]v1.Taint{}
+	for _, taint := range node.Spec.Taints {
+		current[taint.Key+":"+string(taint.Effect)] = taint
+	}
+
+	for key, taint := range current {
+		if _, ok := previous[key]; !ok && !conditionTaints[taint.Key] {
+			t.emitFor(node, "NodeTaintAdded", v1.EventTypeNormal,
+				fmt.Sprintf("node %s tainted with %s", node.Name, describeTaint(taint)))
+		}
+	}
+	for key, taint := range previous {
+		if _, ok := current[key]; !ok && !conditionTaints[taint.Key] {
+			message := fmt.Sprintf("node %s no longer tainted with %s", node.Name, describeTaint(taint))
+			if taint.TimeAdded != nil {
+				message += ": from " + describePeriod(taint.TimeAdded.Time, time.Now())
+			}
+			t.emitFor(node, "NodeTaintRemoved", v1.EventTypeNormal, message)
+		}
+	}
+}
+
+// describeTaint formats a taint like kubectl does
+func describeTaint(taint v1.Taint) string {
+	if taint.Value == "" {
+		return taint.Key + ":" + string(taint.Effect)
+	}
+	return taint.Key + "=" + taint.Value + ":" + string(taint.Effect)
+}
+
+// runningPods counts the running pods of the watched namespaces scheduled on a node
+func (t *nodeTracker) runningPods(node string) int {
+	count := 0
+	for _, nw := range t.pods {
+		for _, obj := range nw.informer.GetStore().List() {
+			if pod, ok := obj.(*v1.Pod); ok && pod.Spec.NodeName == node && pod.Status.Phase == v1.PodRunning {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// emitFor emits an event about a node, adding its running pod count to the message
+func (t *nodeTracker) emitFor(node *v1.Node, reason, severity, message string) {
+	message = strings.TrimSuffix(strings.TrimSpace(message), ":")
+	t.emit(syntheticEvent("Node", node, reason, severity, fmt.Sprintf("%s (%d pods running)", message, t.runningPods(node.Name))))
+}
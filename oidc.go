@@ -0,0 +1,450 @@
+package main
+
+import (
+	"context"         // Request contexts
+	"crypto"          // Hash identifiers
+	"crypto/ecdsa"    // ES* signatures
+	"crypto/elliptic" // ES* curves
+	"crypto/rsa"      // RS* and PS* signatures
+	"crypto/sha256"   // SHA-256 digests
+	"crypto/sha512"   // SHA-384 and SHA-512 digests
+	"encoding/base64" // JWT segment decoding
+	"encoding/json"   // JWT and JWKS decoding
+	"errors"          // Error values
+	"fmt"             // Error formatting
+	"hash"            // Hash interface
+	"math/big"        // Key and signature integers
+	"net/http"        // Fetching discovery documents and JWKS
+	"os"              // Reading local JWKS files
+	"strings"         // String manipulation
+	"sync"            // Mutual exclusion
+	"time"            // For time-related operations
+
+	"github.com/sirupsen/logrus" // Package for structured logging
+)
+
+// Allowed clock difference when checking token lifetimes
+const oidcClockSkew = time.Minute
+
+// Minimum delay between JWKS fetches triggered by unknown key IDs, and first
+// delay before fetching again after a failure
+const jwksMinRefetch = 30 * time.Second
+
+// Maximum delay before fetching the JWKS again after consecutive failures
+const jwksMaxBackoff = 5 * time.Minute
+
+// jsonWebKey is a key of a JSON Web Key Set
+type jsonWebKey struct {
+	Kty string `json:"kty"` // Key type (RSA or EC)
+	Kid string `json:"kid"` // Key ID
+	Use string `json:"use"` // Intended use, "sig" for signing keys
+	N   string `json:"n"`   // RSA modulus
+	E   string `json:"e"`   // RSA exponent
+	Crv string `json:"crv"` // EC curve
+	X   string `json:"x"`   // EC x coordinate
+	Y   string `json:"y"`   // EC y coordinate
+}
+
+// jwtHeader is the header of a JSON Web Token
+type jwtHeader struct {
+	Alg string `json:"alg"` // Signature algorithm
+	Kid string `json:"kid"` // Signing key ID
+}
+
+// oidcAuthenticator validates OIDC ID tokens signed by the keys of an issuer
+type oidcAuthenticator struct {
+	config OIDCConfig   // Issuer and claim settings
+	client *http.Client // Client fetching discovery documents and keys
+
+	mu        sync.Mutex                  // Guards the fields below
+	keys      map[string]crypto.PublicKey // Signing keys by key ID
+	fetchedAt time.Time                   // Time the keys were last fetched
+	jwksURL   string                      // Discovered or configured JWKS location
+	fetching  chan struct{}               // Closed when the fetch in progress ends, nil when none is
+	failures  int                         // Consecutive failed fetches
+	retryAt   time.Time                   // Time before which no fetch is made after a failure
+	fetchErr  error                       // Error of the last failed fetch
+}
+
+// newOIDCAuthenticator creates an authenticator for the configured issuer. Keys
+// are fetched on first use so that the server starts while the issuer is down.
+func newOIDCAuthenticator(config OIDCConfig) *oidcAuthenticator {
+	return &oidcAuthenticator{
+		config:  config,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		jwksURL: config.JWKSURL,
+	}
+}
+
+// authenticate verifies a token and returns the identity in its claims
+func (a *oidcAuthenticator) authenticate(ctx context.Context, token string) (*userInfo, error) {
+	segments := strings.Split(token, ".")
+	if len(segments) != 3 {
+		return nil, fmt.Errorf("%w: malformed token", errUnauthenticated)
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(segments[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: invalid token header", errUnauthenticated)
+	}
+	key, err := a.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(segments[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid token signature", errUnauthenticated)
+	}
+	if err := verifySignature(header.Alg, key, segments[0]+"."+segments[1], signature); err != nil {
+		return nil, fmt.Errorf("%w: %v", errUnauthenticated, err)
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(segments[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: invalid token claims", errUnauthenticated)
+	}
+	if err := a.validateClaims(claims, time.Now()); err != nil {
+		return nil, fmt.Errorf("%w: %v", errUnauthenticated, err)
+	}
+
+	username, _ := claims[a.config.UsernameClaim].(string)
+	if username == "" {
+		return nil, fmt.Errorf("%w: missing %s claim", errUnauthenticated, a.config.UsernameClaim)
+	}
+	user := &userInfo{Username: a.config.UsernamePrefix + username}
+	if sub, ok := claims["sub"].(string); ok {
+		user.UID = sub
+	}
+	for _, group := range claimStrings(claims[a.config.GroupsClaim]) {
+		user.Groups = append(user.Groups, a.config.GroupsPrefix+group)
+	}
+	return user, nil
+}
+
+// validateClaims checks the issuer, audience and lifetime of a token
+func (a *oidcAuthenticator) validateClaims(claims map[string]interface{}, now time.Time) error {
+	if issuer, _ := claims["iss"].(string); a.config.IssuerURL != "" && issuer != a.config.IssuerURL {
+		return fmt.Errorf("unexpected issuer %q", issuer)
+	}
+
+	audienceOK := false
+	for _, audience := range claimStrings(claims["aud"]) {
+		audienceOK = audienceOK || audience == a.config.ClientID
+	}
+	if !audienceOK {
+		return errors.New("token not issued for this client")
+	}
+
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return errors.New("missing exp claim")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(oidcClockSkew)) {
+		return errors.New("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(oidcClockSkew).Before(time.Unix(int64(nbf), 0)) {
+		return errors.New("token not valid yet")
+	}
+	return nil
+}
+
+// claimStrings returns a string or string array claim as a slice
+func claimStrings(claim interface{}) []string {
+	switch value := claim.(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		var values []string
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// decodeSegment decodes a base64url JSON segment of a token
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// key returns the signing key with the given ID, fetching the key set when it is
+// stale or when the key is unknown, which happens after the issuer rotates its keys.
+// A single fetch runs at a time, without holding the lock, and failed fetches are
+// retried with a growing delay.
+func (a *oidcAuthenticator) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	for {
+		a.mu.Lock()
+		now := time.Now()
+		key, known := a.lookupKey(kid)
+		stale := now.Sub(a.fetchedAt) > a.config.RefreshInterval.Duration
+		if !(stale || (!known && now.Sub(a.fetchedAt) > jwksMinRefetch)) || now.Before(a.retryAt) {
+			keys, fetchErr := a.keys, a.fetchErr
+			a.mu.Unlock()
+			switch {
+			case known:
+				return key, nil
+			case keys == nil && fetchErr != nil:
+				return nil, fetchErr
+			}
+			return nil, fmt.Errorf("%w: unknown signing key %q", errUnauthenticated, kid)
+		}
+		if fetching := a.fetching; fetching != nil {
+			a.mu.Unlock()
+			select {
+			case <-fetching:
+				continue
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		fetching := make(chan struct{})
+		a.fetching = fetching
+		jwksURL := a.jwksURL
+		a.mu.Unlock()
+
+		// The fetch outlives the request that triggered it, which others may wait for
+		keys, jwksURL, err := a.fetchKeys(context.Background(), jwksURL)
+
+		a.mu.Lock()
+		if err != nil {
+			a.failures++
+			a.retryAt = time.Now().Add(jwksBackoff(a.failures))
+			a.fetchErr = err
+			if a.keys != nil {
+				// Keeping the previous keys while the issuer is unreachable
+				log.WithFields(logrus.Fields{"error": err, "retryAt": a.retryAt.Format(time.RFC3339)}).Warning("Failed to refresh OIDC signing keys")
+			}
+		} else {
+			a.keys, a.fetchedAt, a.jwksURL = keys, time.Now(), jwksURL
+			a.failures, a.retryAt, a.fetchErr = 0, time.Time{}, nil
+		}
+		a.fetching = nil
+		close(fetching)
+		a.mu.Unlock()
+	}
+}
+
+// jwksBackoff returns how long to wait before fetching the keys again after a
+// number of consecutive failures, doubling from jwksMinRefetch to jwksMaxBackoff
+func jwksBackoff(failures int) time.Duration {
+	delay := jwksMinRefetch
+	for i := 1; i < failures && delay < jwksMaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > jwksMaxBackoff {
+		delay = jwksMaxBackoff
+	}
+	return delay
+}
+
+// lookupKey finds a key by ID, accepting the only key of the set when the token names none
+func (a *oidcAuthenticator) lookupKey(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(a.keys) == 1 {
+		for _, key := range a.keys {
+			return key, true
+		}
+	}
+	key, ok := a.keys[kid]
+	return key, ok
+}
+
+// fetchKeys loads the key set from the JWKS file or URL, discovering the URL from
+// the issuer when not known yet, and returns the keys with the URL
+func (a *oidcAuthenticator) fetchKeys(ctx context.Context, jwksURL string) (map[string]crypto.PublicKey, string, error) {
+	var data []byte
+	var err error
+	if a.config.JWKSFile != "" {
+		data, err = os.ReadFile(a.config.JWKSFile)
+	} else {
+		if jwksURL == "" {
+			if jwksURL, err = a.discoverJWKSURL(ctx); err != nil {
+				return nil, "", err
+			}
+		}
+		data, err = a.get(ctx, jwksURL)
+	}
+	if err != nil {
+		return nil, jwksURL, fmt.Errorf("failed to fetch OIDC signing keys: %w", err)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, jwksURL, fmt.Errorf("invalid JWKS: %w", err)
+	}
+	keys := map[string]crypto.PublicKey{}
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			log.WithFields(logrus.Fields{"kid": jwk.Kid, "error": err}).Warning("Skipping invalid OIDC signing key")
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+
+	log.WithField("keys", len(keys)).Info("Loaded OIDC signing keys")
+	return keys, jwksURL, nil
+}
+
+// discoverJWKSURL reads the JWKS location from the issuer's discovery document
+func (a *oidcAuthenticator) discoverJWKSURL(ctx context.Context) (string, error) {
+	data, err := a.get(ctx, strings.TrimSuffix(a.config.IssuerURL, "/")+"/.well-known/openid-configuration")
+	if err != nil {
+		return "", fmt.Errorf("OIDC discovery failed: %w", err)
+	}
+	var discovery struct {
+		Issuer  string `json:"issuer"`
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := json.Unmarshal(data, &discovery); err != nil {
+		return "", fmt.Errorf("invalid OIDC discovery document: %w", err)
+	}
+	if discovery.Issuer != a.config.IssuerURL {
+		return "", fmt.Errorf("OIDC discovery returned issuer %q, expected %q", discovery.Issuer, a.config.IssuerURL)
+	}
+	if discovery.JWKSURI == "" {
+		return "", errors.New("OIDC discovery document has no jwks_uri")
+	}
+	return discovery.JWKSURI, nil
+}
+
+// get fetches a document over HTTP
+func (a *oidcAuthenticator) get(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+
+	var body json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// publicKey converts a JWK into a public key
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("RSA exponent too large")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("EC point not on curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// decodeBigInt decodes a base64url big-endian integer
+func decodeBigInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+// signatureHash returns the hash used by a JWS algorithm
+func signatureHash(alg string) (crypto.Hash, func() hash.Hash, error) {
+	switch alg[len(alg)-3:] {
+	case "256":
+		return crypto.SHA256, sha256.New, nil
+	case "384":
+		return crypto.SHA384, sha512.New384, nil
+	case "512":
+		return crypto.SHA512, sha512.New, nil
+	}
+	return 0, nil, fmt.Errorf("unsupported algorithm %q", alg)
+}
+
+// verifySignature checks a JWS signature of signed with key
+func verifySignature(alg string, key crypto.PublicKey, signed string, signature []byte) error {
+	if len(alg) != 5 {
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	hashID, newHash, err := signatureHash(alg)
+	if err != nil {
+		return err
+	}
+	h := newHash()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	switch alg[:2] {
+	case "RS", "PS":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key does not match algorithm %q", alg)
+		}
+		if alg[:2] == "RS" {
+			return rsa.VerifyPKCS1v15(rsaKey, hashID, digest, signature)
+		}
+		return rsa.VerifyPSS(rsaKey, hashID, digest, signature, nil)
+	case "ES":
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key does not match algorithm %q", alg)
+		}
+		size := (ecKey.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return errors.New("invalid signature length")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(ecKey, digest, r, s) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported algorithm %q", alg)
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"           // Request contexts
+	"crypto"            // Hash identifiers
+	"crypto/ecdsa"      // ES256 test keys
+	"crypto/elliptic"   // ES256 curve
+	"crypto/rand"       // Key generation and signatures
+	"crypto/rsa"        // RS256 test keys
+	"crypto/sha256"     // SHA-256 digests
+	"encoding/base64"   // JWT segment encoding
+	"encoding/json"     // JWT and JWKS encoding
+	"errors"            // Error inspection
+	"math/big"          // Key integers
+	"net/http"          // Test issuer handlers
+	"net/http/httptest" // Test issuer server
+	"strings"           // String manipulation
+	"sync/atomic"       // Counting key fetches
+	"testing"           // Test framework
+	"time"              // For time-related operations
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1" // Meta v1 API for Kubernetes
+)
+
+// testIssuer is an OIDC issuer serving discovery and keys over HTTP
+type testIssuer struct {
+	server  *httptest.Server  // Issuer server
+	rsaKey  *rsa.PrivateKey   // Key "rsa-1" of the key set
+	ecKey   *ecdsa.PrivateKey // Key "ec-1" of the key set
+	fetches atomic.Int32      // Key set requests served
+	failing atomic.Bool       // Whether key set requests fail
+}
+
+// newTestIssuer starts an issuer closed at the end of the test
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer := &testIssuer{rsaKey: rsaKey, ecKey: ecKey}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"issuer": issuer.server.URL, "jwks_uri": issuer.server.URL + "/keys"})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		issuer.fetches.Add(1)
+		if issuer.failing.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		encode := func(n *big.Int) string { return base64.RawURLEncoding.EncodeToString(n.Bytes()) }
+		writeJSON(w, http.StatusOK, map[string][]jsonWebKey{"keys": {
+			{Kty: "RSA", Kid: "rsa-1", Use: "sig", N: encode(rsaKey.N), E: encode(big.NewInt(int64(rsaKey.E)))},
+			{Kty: "EC", Kid: "ec-1", Crv: "P-256", X: encode(ecKey.X), Y: encode(ecKey.Y)},
+		}})
+	})
+	issuer.server = httptest.NewServer(mux)
+	t.Cleanup(issuer.server.Close)
+	return issuer
+}
+
+// authenticator returns an authenticator of the issuer for the client "translator"
+func (i *testIssuer) authenticator() *oidcAuthenticator {
+	return newOIDCAuthenticator(OIDCConfig{
+		IssuerURL:       i.server.URL,
+		ClientID:        "translator",
+		RefreshInterval: metav1.Duration{Duration: time.Hour},
+		UsernameClaim:   "email",
+		GroupsClaim:     "groups",
+		GroupsPrefix:    "oidc:",
+	})
+}
+
+// claims returns valid claims of the issuer, modified by the given pairs
+func (i *testIssuer) claims(pairs ...interface{}) map[string]interface{} {
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss":    i.server.URL,
+		"aud":    "translator",
+		"sub":    "1234",
+		"email":  "jane@example.com",
+		"groups": []string{"dev"},
+		"exp":    now.Add(time.Hour).Unix(),
+		"iat":    now.Unix(),
+	}
+	for n := 0; n < len(pairs); n += 2 {
+		if pairs[n+1] == nil {
+			delete(claims, pairs[n].(string))
+		} else {
+			claims[pairs[n].(string)] = pairs[n+1]
+		}
+	}
+	return claims
+}
+
+// sign returns a token of the claims signed with the key of the given ID
+func (i *testIssuer) sign(t *testing.T, alg, kid string, claims map[string]interface{}) string {
+	t.Helper()
+	encode := func(v interface{}) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	signed := encode(jwtHeader{Alg: alg, Kid: kid}) + "." + encode(claims)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	var err error
+	switch alg {
+	case "RS256":
+		signature, err = rsa.SignPKCS1v15(rand.Reader, i.rsaKey, crypto.SHA256, digest[:])
+	case "ES256":
+		var r, s *big.Int
+		if r, s, err = ecdsa.Sign(rand.Reader, i.ecKey, digest[:]); err == nil {
+			signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+		}
+	default:
+		t.Fatalf("unsupported algorithm %s", alg)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestOIDCAuthenticate(t *testing.T) {
+	issuer := newTestIssuer(t)
+	a := issuer.authenticator()
+
+	for _, alg := range []string{"RS256", "ES256"} {
+		kid := map[string]string{"RS256": "rsa-1", "ES256": "ec-1"}[alg]
+		user, err := a.authenticate(context.Background(), issuer.sign(t, alg, kid, issuer.claims()))
+		if err != nil {
+			t.Fatalf("%s token rejected: %v", alg, err)
+		}
+		if user.Username != "jane@example.com" || user.UID != "1234" || len(user.Groups) != 1 || user.Groups[0] != "oidc:dev" {
+			t.Fatalf("%s token authenticated as %+v", alg, user)
+		}
+	}
+	if fetches := issuer.fetches.Load(); fetches != 1 {
+		t.Fatalf("keys fetched %d times, want once", fetches)
+	}
+}
+
+func TestOIDCRejectsInvalidTokens(t *testing.T) {
+	issuer := newTestIssuer(t)
+	a := issuer.authenticator()
+	now := time.Now()
+
+	other := newTestIssuer(t)
+	forged := other.sign(t, "RS256", "rsa-1", issuer.claims())
+	valid := issuer.sign(t, "RS256", "rsa-1", issuer.claims())
+	segments := strings.Split(valid, ".")
+	tampered := segments[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"email":"admin@example.com"}`)) + "." + segments[2]
+
+	tests := []struct {
+		name  string // Case description
+		token string // Token presented
+	}{
+		{"malformed", "not-a-token"},
+		{"signed by another key", forged},
+		{"tampered claims", tampered},
+		{"algorithm of another key type", issuer.sign(t, "ES256", "rsa-1", issuer.claims())},
+		{"expired", issuer.sign(t, "RS256", "rsa-1", issuer.claims("exp", now.Add(-2*oidcClockSkew).Unix()))},
+		{"without exp", issuer.sign(t, "RS256", "rsa-1", issuer.claims("exp", nil))},
+		{"not valid yet", issuer.sign(t, "RS256", "rsa-1", issuer.claims("nbf", now.Add(2*oidcClockSkew).Unix()))},
+		{"other audience", issuer.sign(t, "RS256", "rsa-1", issuer.claims("aud", "dashboard"))},
+		{"other audiences", issuer.sign(t, "RS256", "rsa-1", issuer.claims("aud", []string{"dashboard", "grafana"}))},
+		{"other issuer", issuer.sign(t, "RS256", "rsa-1", issuer.claims("iss", "https://evil.example.com"))},
+		{"without username", issuer.sign(t, "RS256", "rsa-1", issuer.claims("email", nil))},
+		{"unknown key", issuer.sign(t, "RS256", "rsa-2", issuer.claims())},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if user, err := a.authenticate(context.Background(), tt.token); err == nil {
+				t.Fatalf("token accepted as %+v", user)
+			} else if !errors.Is(err, errUnauthenticated) {
+				t.Fatalf("error %v is not an authentication failure", err)
+			}
+		})
+	}
+
+	// Tokens within the clock skew of their lifetime are still accepted
+	for _, claims := range []map[string]interface{}{
+		issuer.claims("exp", now.Add(-oidcClockSkew/2).Unix()),
+		issuer.claims("nbf", now.Add(oidcClockSkew/2).Unix()),
+		issuer.claims("aud", []string{"dashboard", "translator"}),
+	} {
+		if _, err := a.authenticate(context.Background(), issuer.sign(t, "RS256", "rsa-1", claims)); err != nil {
+			t.Fatalf("token with claims %v rejected: %v", claims, err)
+		}
+	}
+}
+
+func TestOIDCUnknownKeyRefetch(t *testing.T) {
+	issuer := newTestIssuer(t)
+	a := issuer.authenticator()
+	token := issuer.sign(t, "RS256", "rsa-2", issuer.claims())
+
+	// The first unknown key loads the set, later ones wait for jwksMinRefetch
+	for i := 0; i < 3; i++ {
+		if _, err := a.authenticate(context.Background(), token); err == nil {
+			t.Fatal("token of an unknown key accepted")
+		}
+	}
+	if fetches := issuer.fetches.Load(); fetches != 1 {
+		t.Fatalf("keys fetched %d times, want once", fetches)
+	}
+
+	a.mu.Lock()
+	a.fetchedAt = a.fetchedAt.Add(-jwksMinRefetch - time.Second)
+	a.mu.Unlock()
+	if _, err := a.authenticate(context.Background(), token); err == nil {
+		t.Fatal("token of an unknown key accepted")
+	}
+	if fetches := issuer.fetches.Load(); fetches != 2 {
+		t.Fatalf("keys fetched %d times, want a refetch for the unknown key", fetches)
+	}
+}
+
+func TestOIDCFetchBackoff(t *testing.T) {
+	issuer := newTestIssuer(t)
+	a := issuer.authenticator()
+	token := issuer.sign(t, "RS256", "rsa-1", issuer.claims())
+
+	// Failures are not retried before the backoff delay
+	issuer.failing.Store(true)
+	for i := 0; i < 3; i++ {
+		if _, err := a.authenticate(context.Background(), token); err == nil {
+			t.Fatal("token accepted without keys")
+		}
+	}
+	if fetches := issuer.fetches.Load(); fetches != 1 {
+		t.Fatalf("keys fetched %d times while backing off, want once", fetches)
+	}
+
+	issuer.failing.Store(false)
+	a.mu.Lock()
+	a.retryAt = time.Now()
+	a.mu.Unlock()
+	if _, err := a.authenticate(context.Background(), token); err != nil {
+		t.Fatalf("token rejected after the issuer recovered: %v", err)
+	}
+
+	// Stale keys are kept while refreshing them fails
+	issuer.failing.Store(true)
+	a.mu.Lock()
+	a.fetchedAt = a.fetchedAt.Add(-2 * time.Hour)
+	a.mu.Unlock()
+	for i := 0; i < 2; i++ {
+		if _, err := a.authenticate(context.Background(), token); err != nil {
+			t.Fatalf("token rejected while refreshing keys fails: %v", err)
+		}
+	}
+	if fetches := issuer.fetches.Load(); fetches != 3 {
+		t.Fatalf("keys fetched %d times, want 3", fetches)
+	}
+
+	for failures, want := range map[int]time.Duration{1: jwksMinRefetch, 2: 2 * jwksMinRefetch, 4: 8 * jwksMinRefetch, 10: jwksMaxBackoff} {
+		if got := jwksBackoff(failures); got != want {
+			t.Errorf("backoff after %d failures is %s, want %s", failures, got, want)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"      // Error formatting
+	"net/http" // HTTP server functionalities
+	"net/url"  // Parsing origins
+	"strings"  // String manipulation
+
+	"github.com/sirupsen/logrus" // Package for structured logging
+)
+
+// originPattern is an entry of the origin allow-list
+type originPattern struct {
+	scheme string // Required scheme, empty for any
+	host   string // Host name, "*.example.com" for any subdomain, "*" for any host
+	port   string // Required port, empty for any
+}
+
+// parseOriginPattern parses "example.com", "*.example.com" or "https://*.example.com:8443"
+func parseOriginPattern(pattern string) (originPattern, error) {
+	if pattern == "*" {
+		return originPattern{host: "*"}, nil
+	}
+	var p originPattern
+	hostPort := pattern
+	if scheme, rest, found := strings.Cut(pattern, "://"); found {
+		p.scheme, hostPort = strings.ToLower(scheme), rest
+	}
+	u, err := url.Parse("//" + hostPort)
+	if err != nil || u.Host == "" || u.Path != "" {
+		return originPattern{}, fmt.Errorf("invalid origin pattern %q", pattern)
+	}
+	p.host, p.port = strings.ToLower(u.Hostname()), u.Port()
+	if strings.Contains(strings.TrimPrefix(p.host, "*."), "*") {
+		return originPattern{}, fmt.Errorf("invalid origin pattern %q: wildcards are only allowed as the first label", pattern)
+	}
+	return p, nil
+}
+
+// matches reports whether an origin URL matches the pattern
+func (p originPattern) matches(origin *url.URL) bool {
+	if p.host == "*" {
+		return true
+	}
+	if p.scheme != "" && p.scheme != strings.ToLower(origin.Scheme) {
+		return false
+	}
+	if p.port != "" && p.port != origin.Port() {
+		return false
+	}
+	host := strings.ToLower(origin.Hostname())
+	if suffix := strings.TrimPrefix(p.host, "*"); suffix != p.host {
+		return strings.HasSuffix(host, suffix) && len(host) > len(suffix)
+	}
+	return host == p.host
+}
+
+// originAllowed reports whether a WebSocket upgrade may be accepted from the request's
+// origin. Requests without an Origin header do not come from browsers and are
+// allowed, same-origin requests always are and the others must match the allow-list.
+func originAllowed(r *http.Request) bool {
+	header := r.Header.Get("Origin")
+	if header == "" {
+		return true
+	}
+	origin, err := url.Parse(header)
+	if err != nil || origin.Host == "" {
+		return false
+	}
+	if strings.EqualFold(origin.Host, r.Host) {
+		return true
+	}
+	for _, pattern := range cfg.WebSocket.AllowedOrigins {
+		if p, err := parseOriginPattern(pattern); err == nil && p.matches(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkOrigin rejects cross-site WebSocket requests with 403, returning false when it did
+func checkOrigin(w http.ResponseWriter, r *http.Request) bool {
+	if originAllowed(r) {
+		return true
+	}
+	log.WithFields(logrus.Fields{
+		"origin": r.Header.Get("Origin"),
+		"host":   r.Host,
+		"remote": r.RemoteAddr,
+	}).Warning("Rejected WebSocket request from disallowed origin")
+	http.Error(w, "Origin not allowed", http.StatusForbidden)
+	return false
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"fmt"     // Message formatting
+	"strings" // String manipulation
+	"sync"    // Mutual exclusion
+	"time"    // For time-related operations
+
+	v1 "k8s.io/api/core/v1"          // Core v1 API for Kubernetes
+	"k8s.io/apimachinery/pkg/fields" // For selecting Kubernetes fields
+	"k8s.io/client-go/kubernetes"    // Kubernetes client
+	"k8s.io/client-go/tools/cache"   // For caching Kubernetes objects
+)
+
+// Interval at which pods are re-examined for conditions that depend on elapsed time
+const podResyncPeriod = time.Minute
+
+// Waiting reasons that are part of a normal container start
+var benignWaitingReasons = map[string]bool{
+	"ContainerCreating": true,
+	"PodInitializing":   true,
+}
+
+// Names of the signals containers are commonly killed with
+var signalNames = map[int32]string{
+	1:  "SIGHUP",
+	2:  "SIGINT",
+	6:  "SIGABRT",
+	9:  "SIGKILL",
+	11: "SIGSEGV",
+	15: "SIGTERM",
+}
+
+// podTracker reports container status transitions of pods as events, such as
+// terminations, restarts, containers stuck waiting and readiness flapping
+type podTracker struct {
+	emit   func(*v1.Event)  // Receiver of the synthetic events
+	config PodWatcherConfig // Thresholds
+
+	mu            sync.Mutex             // Guards the fields below
+	readyChanges  map[string][]time.Time // Recent readiness transitions by pod UID and container
+	flapReported  map[string]time.Time   // Time flapping was last reported by pod UID and container
+	stuckReported map[string]bool        // Init containers reported as stuck by pod UID and container
+}
+
+// newPodTracker creates a pod tracker using the pods settings
+func newPodTracker() objectTracker {
+	return &podTracker{
+		config:        cfg.Pods,
+		readyChanges:  map[string][]time.Time{},
+		flapReported:  map[string]time.Time{},
+		stuckReported: map[string]bool{},
+	}
+}
+
+// start runs a pod informer for every watched namespace
+func (t *podTracker) start(w *clusterWatcher, clientset kubernetes.Interface, stop <-chan struct{}) []cache.InformerSynced {
+	t.emit = w.emit
+	var synced []cache.InformerSynced
+	for _, namespace := range w.namespaces {
+		lw := cache.NewListWatchFromClient(clientset.CoreV1().RESTClient(), "pods", namespace, fields.Everything())
+		nw := w.sharedInformer("pods", namespace, lw, &v1.Pod{}, podResyncPeriod, cache.ResourceEventHandlerFuncs{
+			UpdateFunc: func(old, obj interface{}) {
+				oldPod, ok1 := old.(*v1.Pod)
+				pod, ok2 := obj.(*v1.Pod)
+				if ok1 && ok2 {
+					t.update(oldPod, pod)
+				}
+			},
+			DeleteFunc: func(obj interface{}) {
+				if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+					obj = tombstone.Obj
+				}
+				if pod, ok := obj.(*v1.Pod); ok {
+					t.forget(pod)
+				}
+			},
+		}, true)
+		synced = append(synced, nw.informer.HasSynced)
+	}
+	return synced
+}
+
+// update compares the container statuses of two versions of a pod. Pods listed
+// at startup are only a baseline, so existing state is never reported.
+func (t *podTracker) update(old, pod *v1.Pod) {
+	if old.ResourceVersion != pod.ResourceVersion {
+		t.diffContainers(pod, old.Status.InitContainerStatuses, pod.Status.InitContainerStatuses, true)
+		t.diffContainers(pod, old.Status.ContainerStatuses, pod.Status.ContainerStatuses, false)
+	}
+	t.checkStuckInit(pod)
+}
+
+// diffContainers reports the transitions between the old and new statuses of the
+// containers of a pod
+func (t *podTracker) diffContainers(pod *v1.Pod, oldStatuses, statuses []v1.ContainerStatus, init bool) {
+	previous := map[string]v1.ContainerStatus{}
+	for _, status := range oldStatuses {
+		previous[status.Name] = status
+	}
+	containerType, fieldPath := "container", "spec.containers{%s}"
+	if init {
+		containerType, fieldPath = "init container", "spec.initContainers{%s}"
+	}
+
+	for _, status := range statuses {
+		prev, known := previous[status.Name]
+
+		if terminated := status.State.Terminated; terminated != nil && !sameTermination(prev.State.Terminated, terminated) {
+			// Init containers completing is part of every pod start
+			if !init || terminated.ExitCode != 0 {
+				severity := v1.EventTypeWarning
+				if terminated.ExitCode == 0 {
+					severity = v1.EventTypeNormal
+				}
+				t.emitFor(pod, fmt.Sprintf(fieldPath, status.Name), "ContainerTerminated", severity,
+					fmt.Sprintf("%s %s terminated: %s", containerType, status.Name, describeTermination(terminated)))
+			}
+		}
+
+		if known && status.RestartCount > prev.RestartCount {
+			message := fmt.Sprintf("%s %s restarted (%d restarts)", containerType, status.Name, status.RestartCount)
+			// The termination may have happened between two updates and only show in the last state
+			if last := status.LastTerminationState.Terminated; last != nil && !sameTermination(prev.State.Terminated, last) {
+				message += " after " + describeTermination(last)
+			}
+			t.emitFor(pod, fmt.Sprintf(fieldPath, status.Name), "ContainerRestarted", v1.EventTypeWarning, message)
+		}
+
+		if waiting := status.State.Waiting; waiting != nil && !benignWaitingReasons[waiting.Reason] &&
+			(prev.State.Waiting == nil || prev.State.Waiting.Reason != waiting.Reason) {
+			message := fmt.Sprintf("%s %s waiting: %s", containerType, status.Name, waiting.Reason)
+			if waiting.Message != "" {
+				message += ": " + waiting.Message
+			}
+			t.emitFor(pod, fmt.Sprintf(fieldPath, status.Name), "ContainerWaiting", v1.EventTypeWarning, message)
+		}
+
+		if !init && known && prev.Ready != status.Ready {
+			t.readinessChanged(pod, status.Name)
+		}
+	}
+}
+
+// sameTermination reports whether two terminated states describe the same termination
+func sameTermination(a, b *v1.ContainerStateTerminated) bool {
+	return a != nil && b != nil && a.ContainerID == b.ContainerID && a.FinishedAt.Equal(&b.FinishedAt)
+}
+
+// describeTermination formats the exit code, signal and reason of a termination
+func describeTermination(terminated *v1.ContainerStateTerminated) string {
+	parts := []string{fmt.Sprintf("exit code %d", terminated.ExitCode)}
+
+	signal := terminated.Signal
+	if signal == 0 && terminated.ExitCode > 128 {
+		// Shells report death by signal N as exit code 128+N
+		signal = terminated.ExitCode - 128
+	}
+	if signal != 0 {
+		name, ok := signalNames[signal]
+		if !ok {
+			name = fmt.Sprintf("signal %d", signal)
+		}
+		parts = append(parts, name)
+	}
+	if terminated.Reason != "" {
+		parts = append(parts, "reason "+terminated.Reason)
+	}
+	if terminated.Message != "" {
+		parts = append(parts, strings.TrimSpace(terminated.Message))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// readinessChanged records a readiness transition and reports flapping when the
+// container changed readiness too often within the window
+func (t *podTracker) readinessChanged(pod *v1.Pod, container string) {
+	key := string(pod.UID) + "/" + container
+	now := time.Now()
+
+	t.mu.Lock()
+	changes := []time.Time{now}
+	for _, at := range t.readyChanges[key] {
+		if now.Sub(at) < t.config.FlapWindow.Duration {
+			changes = append(changes, at)
+		}
+	}
+	t.readyChanges[key] = changes
+	flapping := len(changes) >= t.config.FlapThreshold && now.Sub(t.flapReported[key]) >= t.config.FlapWindow.Duration
+	if flapping {
+		t.flapReported[key] = now
+	}
+	t.mu.Unlock()
+
+	if flapping {
+		t.emitFor(pod, "spec.containers{"+container+"}", "ReadinessFlapping", v1.EventTypeWarning,
+			fmt.Sprintf("container %s changed readiness %d times in the last %s", container, len(changes), t.config.FlapWindow.Duration))
+	}
+}
+
+// checkStuckInit reports the first init container of a pending pod when it has not
+// completed within the init timeout, once per container
+func (t *podTracker) checkStuckInit(pod *v1.Pod) {
+	if pod.Status.Phase != v1.PodPending || pod.Status.StartTime == nil {
+		return
+	}
+	elapsed := time.Since(pod.Status.StartTime.Time)
+	if elapsed < t.config.InitTimeout.Duration {
+		return
+	}
+
+	for _, status := range pod.Status.InitContainerStatuses {
+		if status.State.Terminated != nil && status.State.Terminated.ExitCode == 0 {
+			continue
+		}
+
+		key := string(pod.UID) + "/" + status.Name
+		t.mu.Lock()
+		reported := t.stuckReported[key]
+		t.stuckReported[key] = true
+		t.mu.Unlock()
+		if reported {
+			return
+		}
+
+		state := "running"
+		if waiting := status.State.Waiting; waiting != nil {
+			state = "waiting (" + waiting.Reason + ")"
+		}
+		t.emitFor(pod, "spec.initContainers{"+status.Name+"}", "InitContainerStuck", v1.EventTypeWarning,
+			fmt.Sprintf("init container %s has been %s for %s", status.Name, state, elapsed.Round(time.Second)))
+		return
+	}
+}
+
+// forget drops the state kept for a deleted pod
+func (t *podTracker) forget(pod *v1.Pod) {
+	prefix := string(pod.UID) + "/"
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for key := range t.readyChanges {
+		if strings.HasPrefix(key, prefix) {
+			delete(t.readyChanges, key)
+		}
+	}
+	for key := range t.flapReported {
+		if strings.HasPrefix(key, prefix) {
+			delete(t.flapReported, key)
+		}
+	}
+	for key := range t.stuckReported {
+		if strings.HasPrefix(key, prefix) {
+			delete(t.stuckReported, key)
+		}
+	}
+}
+
+// emitFor emits an event about the container of a pod at fieldPath
+func (t *podTracker) emitFor(pod *v1.Pod, fieldPath, reason, severity, message string) {
+	event := syntheticEvent("Pod", pod, reason, severity, message)
+	event.InvolvedObject.FieldPath = fieldPath
+	t.emit(event)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"compress/gzip" // Compression of archive files
+	"encoding/json" // For JSON encoding
+	"fmt"           // String formatting
+	"io"            // Stream interfaces
+	"os"            // File access
+	"path/filepath" // For manipulating filename paths
+	"sync"          // Mutual exclusion
+	"time"          // For time-related operations
+
+	"github.com/sirupsen/logrus"    // Package for structured logging
+	v1 "k8s.io/api/core/v1"         // Core v1 API for Kubernetes
+	"k8s.io/apimachinery/pkg/watch" // Watch event types
+)
+
+// recordedEvent is one line of a recording archive
+type recordedEvent struct {
+	Type            string    `json:"type"`            // Watch event type (ADDED, MODIFIED, DELETED)
+	Cluster         string    `json:"cluster"`         // Cluster the event comes from
+	ReceivedAt      time.Time `json:"receivedAt"`      // Time the informer received the event
+	ResourceVersion string    `json:"resourceVersion"` // Original resourceVersion of the event
+	Event           *v1.Event `json:"event"`           // Raw Kubernetes event
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer // Underlying writer
+	n int64     // Bytes written so far
+}
+
+// Write writes p to the underlying writer and counts it
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// recorder writes raw Kubernetes events to gzip JSONL files, rotating them by size and age
+type recorder struct {
+	mu      sync.Mutex      // Guards the fields below
+	dir     string          // Directory holding the archive files
+	maxSize int64           // Maximum compressed size of a file in bytes (0 = unlimited)
+	maxAge  time.Duration   // Maximum age of a file (0 = unlimited)
+	file    *os.File        // Current archive file
+	counter *countingWriter // Counts compressed bytes written to file
+	gz      *gzip.Writer    // Compressor writing to counter
+	opened  time.Time       // Time the current file was opened
+	closed  bool            // Set once the archive is finished
+}
+
+// newRecorder creates a recorder writing into dir
+func newRecorder(dir string, maxSize int64, maxAge time.Duration) (*recorder, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &recorder{dir: dir, maxSize: maxSize, maxAge: maxAge}, nil
+}
+
+// record appends an event to the archive
+func (rec *recorder) record(cluster string, eventType watch.EventType, event *v1.Event) error {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	if rec.closed {
+		return nil
+	}
+
+	now := time.Now()
+	if rec.needsRotation(now) {
+		if err := rec.rotate(now); err != nil {
+			return err
+		}
+	}
+
+	event = redaction.redactKubernetesEvent(event)
+	line, err := json.Marshal(recordedEvent{
+		Type:            string(eventType),
+		Cluster:         cluster,
+		ReceivedAt:      now.UTC(),
+		ResourceVersion: event.ResourceVersion,
+		Event:           event,
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := rec.gz.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	// Flushing keeps the archive readable up to the last event if the process dies
+	return rec.gz.Flush()
+}
+
+// needsRotation reports whether a new archive file must be started
+func (rec *recorder) needsRotation(now time.Time) bool {
+	switch {
+	case rec.file == nil:
+		return true
+	case rec.maxSize > 0 && rec.counter.n >= rec.maxSize:
+		return true
+	case rec.maxAge > 0 && now.Sub(rec.opened) >= rec.maxAge:
+		return true
+	}
+	return false
+}
+
+// rotate closes the current archive file and opens a new one
+func (rec *recorder) rotate(now time.Time) error {
+	if err := rec.closeFile(); err != nil {
+		return err
+	}
+
+	// File names sort in recording order, which replay relies on
+	name := filepath.Join(rec.dir, fmt.Sprintf("events-%s.jsonl.gz", now.UTC().Format("20060102T150405.000Z")))
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+
+	rec.file = file
+	rec.counter = &countingWriter{w: file}
+	rec.gz = gzip.NewWriter(rec.counter)
+	rec.opened = now
+	log.WithField("file", name).Info("Started new recording file")
+	return nil
+}
+
+// closeFile finishes the current archive file, if any
+func (rec *recorder) closeFile() error {
+	if rec.file == nil {
+		return nil
+	}
+	err := rec.gz.Close()
+	if closeErr := rec.file.Close(); err == nil {
+		err = closeErr
+	}
+	rec.file, rec.counter, rec.gz = nil, nil, nil
+	return err
+}
+
+// Close finishes the archive
+func (rec *recorder) Close() error {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.closed = true
+	return rec.closeFile()
+}
+
+// handle records a change to an event of a cluster, logging failures
+func (rec *recorder) handle(cluster string, eventType watch.EventType, event *v1.Event) {
+	if err := rec.record(cluster, eventType, event); err != nil {
+		log.WithFields(logrus.Fields{
+			"cluster": cluster,
+			"event":   event.Name,
+			"error":   err,
+		}).Error("Failed to record Kubernetes event")
+	}
+}
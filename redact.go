@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json" // Encoding redacted diffs
+	"fmt"           // Error formatting
+	"net"           // Classifying IP addresses
+	"regexp"        // Detector patterns
+	"strings"       // String building
+	"sync/atomic"   // Rule counters
+
+	v1 "k8s.io/api/core/v1" // Core v1 API for Kubernetes
+)
+
+// Built-in redaction detectors
+const (
+	detectorToken       = "token"       // Bearer tokens, JWTs and token query parameters
+	detectorCredentials = "credentials" // URL user info, passwords and registry auths
+	detectorEmail       = "email"       // Email addresses
+	detectorIP          = "ip"          // Private IPv4 addresses
+)
+
+// Name of the pattern group holding the secret part of a match; the whole match
+// is masked when a pattern has no such group
+const secretGroup = "secret"
+
+// builtinDetectors are the patterns of the built-in detectors, applied in this order
+var builtinDetectors = []struct {
+	name     string            // Detector name
+	patterns []string          // Patterns of the detector
+	accept   func(string) bool // Extra check of a match, nil to accept every match
+}{
+	{detectorToken, []string{
+		`(?i)\bbearer\s+(?P<secret>[A-Za-z0-9\-._~+/]+=*)`,
+		`(?i)[?&](?:access_token|id_token|token|api_key|apikey|key|sig|signature)=(?P<secret>[^&\s"']+)`,
+		`\beyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+`,
+	}, nil},
+	{detectorCredentials, []string{
+		`://(?P<secret>[^/\s:@]+:[^/\s@]+)@`,
+		`(?i)\b(?:password|passwd|pwd|secret)\s*[=:]\s*(?P<secret>[^\s,;"']+)`,
+		`(?i)\bbasic\s+(?P<secret>[A-Za-z0-9+/]{8,}=*)`,
+		`"auth"\s*:\s*"(?P<secret>[^"]+)"`,
+	}, nil},
+	{detectorEmail, []string{
+		`\b[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}\b`,
+	}, nil},
+	{detectorIP, []string{
+		`\b(?:\d{1,3}\.){3}\d{1,3}\b`,
+	}, func(match string) bool {
+		ip := net.ParseIP(match)
+		return ip != nil && (ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast())
+	}},
+}
+
+// redactionRule masks the matches of its patterns with a typed placeholder
+type redactionRule struct {
+	name        string            // Rule name, reported with its counter
+	placeholder string            // Replacement of matches
+	patterns    []*regexp.Regexp  // Patterns detecting sensitive data
+	accept      func(string) bool // Extra check of a match, nil to accept every match
+	hits        atomic.Int64      // Number of masked matches
+}
+
+// apply masks the matches of the rule in s, adding them to its counter when count is set
+func (rule *redactionRule) apply(s string, count bool) string {
+	for _, pattern := range rule.patterns {
+		matches := pattern.FindAllStringSubmatchIndex(s, -1)
+		if matches == nil {
+			continue
+		}
+		group := pattern.SubexpIndex(secretGroup)
+
+		var b strings.Builder
+		last := 0
+		for _, match := range matches {
+			start, end := match[0], match[1]
+			if group >= 0 && match[2*group] >= 0 {
+				start, end = match[2*group], match[2*group+1]
+			}
+			if rule.accept != nil && !rule.accept(s[start:end]) {
+				continue
+			}
+			b.WriteString(s[last:start])
+			b.WriteString(rule.placeholder)
+			last = end
+			if count {
+				rule.hits.Add(1)
+			}
+		}
+		b.WriteString(s[last:])
+		s = b.String()
+	}
+	return s
+}
+
+// redactor removes sensitive data from event messages before they leave the process
+type redactor struct {
+	rules []*redactionRule // Rules applied in order
+	count bool             // Count the masked matches in the rule counters
+}
+
+// Redactor applied to every outgoing event, configured in main
+var redaction = &redactor{}
+
+// newRedactor creates a redactor with the enabled built-in detectors followed by the custom rules
+func newRedactor(config RedactionConfig) (*redactor, error) {
+	r := &redactor{}
+	enabled := map[string]bool{}
+	for _, name := range config.Detectors {
+		enabled[name] = true
+	}
+	for _, detector := range builtinDetectors {
+		if !enabled[detector.name] {
+			continue
+		}
+		delete(enabled, detector.name)
+		rule := &redactionRule{name: detector.name, placeholder: "[REDACTED:" + detector.name + "]", accept: detector.accept}
+		for _, pattern := range detector.patterns {
+			rule.patterns = append(rule.patterns, regexp.MustCompile(pattern))
+		}
+		r.rules = append(r.rules, rule)
+	}
+	for name := range enabled {
+		return nil, fmt.Errorf("unknown redaction detector %q", name)
+	}
+
+	for _, custom := range config.Rules {
+		if custom.Name == "" {
+			return nil, fmt.Errorf("redaction rule %q has no name", custom.Pattern)
+		}
+		pattern, err := regexp.Compile(custom.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern of redaction rule %q: %w", custom.Name, err)
+		}
+		placeholder := custom.Placeholder
+		if placeholder == "" {
+			placeholder = "[REDACTED:" + custom.Name + "]"
+		}
+		r.rules = append(r.rules, &redactionRule{name: custom.Name, placeholder: placeholder, patterns: []*regexp.Regexp{pattern}})
+	}
+	return r, nil
+}
+
+// counted returns a redactor with the rules of r counting the matches it masks.
+// The same event is redacted for every consumer and client, so only the path
+// publishing each event once counts.
+func (r *redactor) counted() *redactor {
+	return &redactor{rules: r.rules, count: true}
+}
+
+// redact masks sensitive data in s
+func (r *redactor) redact(s string) string {
+	for _, rule := range r.rules {
+		s = rule.apply(s, r.count)
+	}
+	return s
+}
+
+// redactEvent returns event with its message redacted
+func (r *redactor) redactEvent(event Event) Event {
+	event.Object.Message = r.redact(event.Object.Message)
+	if len(event.Object.Diff) > 0 {
+		event.Object.Diff = redactOperations(r, event.Object.Diff)
+	}
+	return event
+}
+
+// redactKubernetesEvent returns event with its message redacted, copying it
+// when it changes since informer objects are shared
+func (r *redactor) redactKubernetesEvent(event *v1.Event) *v1.Event {
+	if message := r.redact(event.Message); message != event.Message {
+		event = event.DeepCopy()
+		event.Message = message
+	}
+	if operations := eventDiff(event); len(operations) > 0 {
+		if encoded, err := json.Marshal(redactOperations(r, operations)); err == nil && string(encoded) != event.Annotations[diffAnnotation] {
+			event = event.DeepCopy()
+			event.Annotations[diffAnnotation] = string(encoded)
+		}
+	}
+	return event
+}
+
+// counts returns how many matches each rule masked
+func (r *redactor) counts() map[string]int64 {
+	counts := map[string]int64{}
+	for _, rule := range r.rules {
+		counts[rule.name] += rule.hits.Load()
+	}
+	return counts
+}
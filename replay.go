@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bufio"         // Buffered reading
+	"compress/gzip" // Decompression of archive files
+	"context"       // Request contexts
+	"encoding/json" // For JSON decoding
+	"fmt"           // Error formatting
+	"io"            // Stream interfaces
+	"net/http"      // HTTP server functionalities
+	"os"            // File access
+	"path/filepath" // For manipulating filename paths
+	"sort"          // Sorting archive files
+	"sync/atomic"   // Client counter
+	"time"          // For time-related operations
+
+	"github.com/sirupsen/logrus"    // Package for structured logging
+	v1 "k8s.io/api/core/v1"         // Core v1 API for Kubernetes
+	"k8s.io/apimachinery/pkg/watch" // Watch event types
+)
+
+// Number of clients currently receiving a replay
+var replayClients atomic.Int64
+
+// replayRecord is a single event read from a recording, with the time used for pacing
+type replayRecord struct {
+	Event Event     // Translated event to stream
+	At    time.Time // Original time of the event (zero if unknown)
+}
+
+// replayProbe detects which format a recorded JSON value is in
+type replayProbe struct {
+	Items          []json.RawMessage `json:"items"`          // Set for `kubectl get events -o json` lists
+	InvolvedObject json.RawMessage   `json:"involvedObject"` // Set for raw v1.Event objects
+	Object         json.RawMessage   `json:"object"`         // Set for translator Event objects
+	Event          json.RawMessage   `json:"event"`          // Set for recorded events written by --record
+}
+
+// replayReader reads events from a recording. The recording is a stream of JSON
+// values (one per line or pretty-printed), each being a translator Event, a raw
+// v1.Event, a v1.EventList as printed by `kubectl get events -o json`, or a
+// recordedEvent written by record mode.
+type replayReader struct {
+	decoder *json.Decoder  // Decoder over the recording
+	pending []replayRecord // Records decoded but not yet returned
+}
+
+// newReplayReader creates a replayReader over r
+func newReplayReader(r io.Reader) *replayReader {
+	return &replayReader{decoder: json.NewDecoder(r)}
+}
+
+// next returns the next record of the recording, or io.EOF when it is exhausted
+func (rr *replayReader) next() (replayRecord, error) {
+	for len(rr.pending) == 0 {
+		var raw json.RawMessage
+		if err := rr.decoder.Decode(&raw); err != nil {
+			return replayRecord{}, err
+		}
+		records, err := decodeReplayValue(raw)
+		if err != nil {
+			return replayRecord{}, err
+		}
+		rr.pending = records
+	}
+
+	record := rr.pending[0]
+	rr.pending = rr.pending[1:]
+	return record, nil
+}
+
+// decodeReplayValue decodes one JSON value of a recording into records
+func decodeReplayValue(raw json.RawMessage) ([]replayRecord, error) {
+	var probe replayProbe
+	if err := json.Unmarshal(raw, &probe); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case probe.Items != nil:
+		// Event list, decode every item
+		var records []replayRecord
+		for _, item := range probe.Items {
+			itemRecords, err := decodeReplayValue(item)
+			if err != nil {
+				return nil, err
+			}
+			records = append(records, itemRecords...)
+		}
+		return records, nil
+	case probe.InvolvedObject != nil:
+		// Raw Kubernetes event, translated like a live one
+		var event v1.Event
+		if err := json.Unmarshal(raw, &event); err != nil {
+			return nil, err
+		}
+		return []replayRecord{{Event: translateEvent("", "ADDED", &event), At: eventTime(&event)}}, nil
+	case probe.Event != nil:
+		// Event recorded by record mode, paced by the time it was received
+		var recorded recordedEvent
+		if err := json.Unmarshal(raw, &recorded); err != nil {
+			return nil, err
+		}
+		// Only what live clients were sent is replayed
+		if recorded.Event == nil || !streamed(watch.EventType(recorded.Type), recorded.Event) {
+			return nil, nil
+		}
+		return []replayRecord{{Event: translateEvent(recorded.Cluster, recorded.Type, recorded.Event), At: recorded.ReceivedAt}}, nil
+	case probe.Object != nil:
+		// Already translated event
+		var event Event
+		if err := json.Unmarshal(raw, &event); err != nil {
+			return nil, err
+		}
+		at, _ := time.ParseInLocation(timestampLayout, event.Timestamp, time.Local)
+		return []replayRecord{{Event: redaction.redactEvent(event), At: at}}, nil
+	}
+	return nil, fmt.Errorf("unrecognized recording entry: %.80s", raw)
+}
+
+// eventTime returns the most recent time recorded on a Kubernetes event
+func eventTime(event *v1.Event) time.Time {
+	switch {
+	case !event.LastTimestamp.IsZero():
+		return event.LastTimestamp.Time
+	case !event.EventTime.IsZero():
+		return event.EventTime.Time
+	case !event.FirstTimestamp.IsZero():
+		return event.FirstTimestamp.Time
+	}
+	return event.CreationTimestamp.Time
+}
+
+// replayDelay returns how long to wait between two recorded events at the given speed.
+// A speed of 0 or less replays as fast as possible.
+func replayDelay(previous, current time.Time, speed float64) time.Duration {
+	if speed <= 0 || previous.IsZero() || current.IsZero() || !current.After(previous) {
+		return 0
+	}
+	return time.Duration(float64(current.Sub(previous)) / speed)
+}
+
+// recording is the concatenated content of one or more recording files
+type recording struct {
+	io.Reader            // Concatenated, decompressed content
+	files     []*os.File // Open files backing the reader
+}
+
+// Close closes every file of the recording
+func (r *recording) Close() error {
+	var err error
+	for _, file := range r.files {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// openRecording opens a recording file, or every file of a directory in name
+// order as written by record mode. Gzip-compressed files are decompressed.
+func openRecording(path string) (*recording, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := []string{path}
+	if info.IsDir() {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		paths = paths[:0]
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				paths = append(paths, filepath.Join(path, entry.Name()))
+			}
+		}
+		sort.Strings(paths)
+	}
+
+	rec := &recording{}
+	var readers []io.Reader
+	for _, p := range paths {
+		file, err := os.Open(p)
+		if err != nil {
+			rec.Close()
+			return nil, err
+		}
+		rec.files = append(rec.files, file)
+
+		reader, err := decompressedReader(file)
+		if err != nil {
+			rec.Close()
+			return nil, fmt.Errorf("%s: %w", p, err)
+		}
+		readers = append(readers, reader)
+	}
+	rec.Reader = io.MultiReader(readers...)
+	return rec, nil
+}
+
+// decompressedReader returns a reader over r, decompressing it if it is gzip data
+func decompressedReader(r io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReader(r)
+	magic, err := buffered.Peek(2)
+	if err != nil || magic[0] != 0x1f || magic[1] != 0x8b {
+		// Not gzip (or too short to be), read as plain text
+		return buffered, nil
+	}
+
+	gz, err := gzip.NewReader(buffered)
+	if err != nil {
+		return nil, err
+	}
+	return truncatedGzipReader{gz}, nil
+}
+
+// truncatedGzipReader ends a gzip stream cleanly when its trailer is missing, which
+// happens for the file being written when a recorder is killed
+type truncatedGzipReader struct {
+	r io.Reader // Gzip reader
+}
+
+// Read reads from the gzip stream, reporting a truncated stream as its end
+func (t truncatedGzipReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// handleReplay streams a recording over a WebSocket connection, starting from the
+// beginning of the file for every client
+func handleReplay(w http.ResponseWriter, r *http.Request, path string, speed float64, authz namespaceAuthorizer) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	// Rejecting cross-site requests before any other work
+	if !checkOrigin(w, r) {
+		return
+	}
+
+	// Subscription filters requested by the client, restricted to the authorized namespaces
+	sub := parseSubscription(r)
+	if !authorizeRequest(ctx, w, r, authz, &sub) {
+		return
+	}
+
+	ws, err := upgradeConnection(w, r)
+	if err != nil {
+		return
+	}
+
+	// Close WebSocket connection on function exit
+	defer ws.Close()
+
+	replayClients.Add(1)
+	defer replayClients.Add(-1)
+
+	logger := log.WithField("file", path)
+	if user := requestUser(r); user != nil {
+		logger = logger.WithFields(user.fields())
+	}
+	logger.Info("Replay client connected")
+
+	// Detecting when the client goes away
+	done := watchClose(ws)
+
+	recording, err := openRecording(path)
+	if err != nil {
+		log.WithFields(logrus.Fields{"file": path, "error": err}).Error("Failed to open replay file")
+		return
+	}
+	defer recording.Close()
+
+	reader := newReplayReader(recording)
+	var previous time.Time
+	for {
+		record, err := reader.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.WithFields(logrus.Fields{"file": path, "error": err}).Error("Failed to read replay file")
+			return
+		}
+
+		// Waiting to keep the original pace between events
+		if delay := replayDelay(previous, record.At, speed); delay > 0 {
+			select {
+			case <-time.After(delay):
+			case <-done:
+				return
+			}
+		}
+		if record.At.After(previous) {
+			previous = record.At
+		}
+
+		// Logging the event
+		if cfg.outputEnabled(outputLog) {
+			logEvent(record.Event)
+		}
+		if err := sendEvent(ws, sub, record.Event); err != nil {
+			log.WithField("error", err).Warning("WebSocket write error, stopping replay")
+			return
+		}
+	}
+
+	log.WithField("file", path).Info("Replay finished")
+	<-done // Keeping the WebSocket connection open until the client leaves
+}
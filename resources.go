@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"       // Request contexts for the API
+	"encoding/json" // Formatting rule values
+	"fmt"           // Message formatting
+	"regexp"        // Warning patterns
+	"strings"       // String manipulation
+	"sync"          // Mutual exclusion
+	"unicode"       // Building reasons from rule names
+
+	"github.com/sirupsen/logrus"                        // Package for structured logging
+	v1 "k8s.io/api/core/v1"                             // Core v1 API for Kubernetes
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"       // Meta v1 API for Kubernetes
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured" // Objects of any type
+	"k8s.io/apimachinery/pkg/runtime"                   // Kubernetes runtime objects
+	"k8s.io/apimachinery/pkg/runtime/schema"            // Group, version and resource names
+	"k8s.io/apimachinery/pkg/watch"                     // For watching Kubernetes resources
+	"k8s.io/client-go/dynamic"                          // Client for resources of any type
+	"k8s.io/client-go/kubernetes"                       // Kubernetes client
+	"k8s.io/client-go/tools/cache"                      // For caching Kubernetes objects
+	"k8s.io/client-go/util/jsonpath"                    // Selecting fields of objects
+)
+
+// resourceRule is a compiled rule of a dynamic resource watcher
+type resourceRule struct {
+	name    string             // Rule name used in messages
+	path    *jsonpath.JSONPath // Fields compared between versions
+	reason  string             // Reason of the events
+	warning *regexp.Regexp     // Values reported as warnings, nil for none
+}
+
+// resourceTracker watches a configured resource through the dynamic client and
+// reports changes of the fields selected by its rules as events
+type resourceTracker struct {
+	emit   func(*v1.Event)             // Receiver of the synthetic events
+	client dynamic.Interface           // Client of the cluster
+	config ResourceWatcherConfig       // Watched resource and rules
+	gvr    schema.GroupVersionResource // Watched resource
+	kind   string                      // Kind named in events
+	rules  []resourceRule              // Compiled rules
+
+	mu sync.Mutex // Serializes rule evaluation, as JSONPath keeps state while evaluating
+}
+
+// newResourceTracker creates the tracker of a configured resource
+func newResourceTracker(config ResourceWatcherConfig, client dynamic.Interface) (*resourceTracker, error) {
+	t := &resourceTracker{
+		client: client,
+		config: config,
+		gvr:    schema.GroupVersionResource{Group: config.Group, Version: config.Version, Resource: config.Resource},
+		kind:   config.Kind,
+	}
+	if t.kind == "" {
+		t.kind = config.Resource
+	}
+	for _, rule := range config.Rules {
+		compiled, err := compileResourceRule(rule)
+		if err != nil {
+			return nil, fmt.Errorf("resource %s rule %q: %w", t.gvr.String(), rule.Name, err)
+		}
+		t.rules = append(t.rules, compiled)
+	}
+	return t, nil
+}
+
+// compileResourceRule parses the JSONPath and warning pattern of a rule
+func compileResourceRule(rule ResourceRule) (resourceRule, error) {
+	expression := rule.JSONPath
+	if !strings.HasPrefix(expression, "{") {
+		expression = "{" + expression + "}"
+	}
+	path := jsonpath.New(rule.Name).AllowMissingKeys(true)
+	if err := path.Parse(expression); err != nil {
+		return resourceRule{}, fmt.Errorf("invalid JSONPath %q: %w", rule.JSONPath, err)
+	}
+
+	compiled := resourceRule{name: rule.Name, path: path, reason: rule.Reason}
+	if rule.Warning != "" {
+		warning, err := regexp.Compile(rule.Warning)
+		if err != nil {
+			return resourceRule{}, fmt.Errorf("invalid warning pattern %q: %w", rule.Warning, err)
+		}
+		compiled.warning = warning
+	}
+	return compiled, nil
+}
+
+// start resolves the scope and kind of the resource, then runs its informers: one
+// per watched namespace, or one for the cluster when the resource is not namespaced
+func (t *resourceTracker) start(w *clusterWatcher, clientset kubernetes.Interface, stop <-chan struct{}) []cache.InformerSynced {
+	t.emit = w.emit
+	namespaced := t.discover(w, clientset)
+	for i := range t.rules {
+		if t.rules[i].reason == "" {
+			t.rules[i].reason = t.kind + camelCase(t.rules[i].name) + "Changed"
+		}
+	}
+
+	namespaces := w.namespaces
+	if !namespaced {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+	var synced []cache.InformerSynced
+	for _, namespace := range namespaces {
+		resource := t.client.Resource(t.gvr).Namespace(namespace)
+		lw := &cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				return resource.List(context.Background(), options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				return resource.Watch(context.Background(), options)
+			},
+		}
+		nw := w.startInformer(t.gvr.String(), namespace, lw, &unstructured.Unstructured{}, 0, cache.ResourceEventHandlerFuncs{
+			UpdateFunc: func(old, obj interface{}) {
+				oldObject, ok1 := old.(*unstructured.Unstructured)
+				object, ok2 := obj.(*unstructured.Unstructured)
+				if ok1 && ok2 && oldObject.GetResourceVersion() != object.GetResourceVersion() {
+					t.update(oldObject, object)
+				}
+			},
+		}, true, stop)
+		synced = append(synced, nw.informer.HasSynced)
+	}
+	return synced
+}
+
+// discover looks the resource up in the API discovery to learn whether it is
+// namespaced and its kind, assuming a namespaced resource when it cannot be found
+func (t *resourceTracker) discover(w *clusterWatcher, clientset kubernetes.Interface) bool {
+	resources, err := clientset.Discovery().ServerResourcesForGroupVersion(t.gvr.GroupVersion().String())
+	if err == nil {
+		for _, resource := range resources.APIResources {
+			if resource.Name != t.gvr.Resource {
+				continue
+			}
+			if t.config.Kind == "" {
+				t.kind = resource.Kind
+			}
+			return resource.Namespaced
+		}
+		err = fmt.Errorf("resource not served")
+	}
+	log.WithFields(logrus.Fields{"cluster": w.name, "resource": t.gvr.String(), "error": err}).Warning("Failed to discover resource, assuming it is namespaced")
+	return true
+}
+
+// update reports the rules whose selected fields differ between two versions of an object
+func (t *resourceTracker) update(old, object *unstructured.Unstructured) {
+	var events []*v1.Event
+	t.mu.Lock()
+	for _, rule := range t.rules {
+		previous, err1 := rule.evaluate(old)
+		current, err2 := rule.evaluate(object)
+		if err1 != nil || err2 != nil {
+			log.WithFields(logrus.Fields{"resource": t.gvr.String(), "rule": rule.name, "object": object.GetNamespace() + "/" + object.GetName()}).
+				Debug("Failed to evaluate rule")
+			continue
+		}
+		if previous == current {
+			continue
+		}
+
+		severity := v1.EventTypeNormal
+		if rule.warning != nil && rule.warning.MatchString(current) {
+			severity = v1.EventTypeWarning
+		}
+		events = append(events, syntheticEvent(t.kind, object, rule.reason, severity, fmt.Sprintf("%s %s %s: %s -> %s",
+			strings.ToLower(t.kind), object.GetName(), rule.name, describeRuleValue(previous), describeRuleValue(current))))
+	}
+	t.mu.Unlock()
+
+	for _, event := range events {
+		t.emit(event)
+	}
+}
+
+// evaluate returns the fields a rule selects in an object as compact JSON, several
+// matches separated by commas
+func (r resourceRule) evaluate(object *unstructured.Unstructured) (string, error) {
+	results, err := r.path.FindResults(object.UnstructuredContent())
+	if err != nil {
+		return "", err
+	}
+	var values []string
+	for _, result := range results {
+		for _, value := range result {
+			if !value.IsValid() || !value.CanInterface() {
+				continue
+			}
+			if s, ok := value.Interface().(string); ok {
+				values = append(values, s)
+				continue
+			}
+			encoded, err := json.Marshal(value.Interface())
+			if err != nil {
+				return "", err
+			}
+			values = append(values, string(encoded))
+		}
+	}
+	return strings.Join(values, ","), nil
+}
+
+// describeRuleValue formats a rule value, naming the empty value
+func describeRuleValue(value string) string {
+	if value == "" {
+		return "<none>"
+	}
+	return value
+}
+
+// camelCase turns a rule name such as "ready-condition" into "ReadyCondition"
+func camelCase(name string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"fmt"     // Message formatting
+	"sort"    // Ordering image changes
+	"strings" // String manipulation
+	"sync"    // Mutual exclusion
+	"time"    // For time-related operations
+
+	appsv1 "k8s.io/api/apps/v1"      // Apps v1 API for Kubernetes
+	v1 "k8s.io/api/core/v1"          // Core v1 API for Kubernetes
+	"k8s.io/apimachinery/pkg/fields" // For selecting Kubernetes fields
+	"k8s.io/client-go/kubernetes"    // Kubernetes client
+	"k8s.io/client-go/tools/cache"   // For caching Kubernetes objects
+)
+
+// Annotations maintained by the deployment controller and kubectl
+const (
+	revisionAnnotation    = "deployment.kubernetes.io/revision"
+	changeCauseAnnotation = "kubernetes.io/change-cause"
+)
+
+// rolloutState is the progress of a rollout in flight
+type rolloutState struct {
+	revision  string    // Revision being rolled out
+	startedAt time.Time // Time the rollout was noticed
+	progress  string    // Last reported progress
+	stalled   bool      // Whether the stall was reported
+}
+
+// rolloutTracker reports the rollouts of Deployments as events: started, progress,
+// stalled and completed, with the revision, change cause and image changes
+type rolloutTracker struct {
+	emit        func(*v1.Event)   // Receiver of the synthetic events
+	replicaSets []*namespaceWatch // ReplicaSets of every watched namespace
+
+	mu       sync.Mutex               // Guards rollouts
+	rollouts map[string]*rolloutState // Rollouts in flight by deployment UID
+}
+
+// newRolloutTracker creates a rollout tracker
+func newRolloutTracker() objectTracker {
+	return &rolloutTracker{rollouts: map[string]*rolloutState{}}
+}
+
+// start runs Deployment and ReplicaSet informers for every watched namespace
+func (t *rolloutTracker) start(w *clusterWatcher, clientset kubernetes.Interface, stop <-chan struct{}) []cache.InformerSynced {
+	t.emit = w.emit
+	restClient := clientset.AppsV1().RESTClient()
+
+	var synced []cache.InformerSynced
+	for _, namespace := range w.namespaces {
+		rs := w.sharedInformer("replicasets", namespace,
+			cache.NewListWatchFromClient(restClient, "replicasets", namespace, fields.Everything()),
+			&appsv1.ReplicaSet{}, 0, cache.ResourceEventHandlerFuncs{}, false)
+		t.replicaSets = append(t.replicaSets, rs)
+
+		deployments := w.sharedInformer("deployments", namespace,
+			cache.NewListWatchFromClient(restClient, "deployments", namespace, fields.Everything()),
+			&appsv1.Deployment{}, 0, cache.ResourceEventHandlerFuncs{
+				UpdateFunc: func(old, obj interface{}) {
+					oldDeployment, ok1 := old.(*appsv1.Deployment)
+					deployment, ok2 := obj.(*appsv1.Deployment)
+					if ok1 && ok2 && oldDeployment.ResourceVersion != deployment.ResourceVersion {
+						t.update(oldDeployment, deployment)
+					}
+				},
+				DeleteFunc: func(obj interface{}) {
+					if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+						obj = tombstone.Obj
+					}
+					if deployment, ok := obj.(*appsv1.Deployment); ok {
+						t.mu.Lock()
+						delete(t.rollouts, string(deployment.UID))
+						t.mu.Unlock()
+					}
+				},
+			}, true)
+		synced = append(synced, rs.informer.HasSynced, deployments.informer.HasSynced)
+	}
+	return synced
+}
+
+// update follows the rollout of a deployment from one version to the next. Rollouts
+// already in flight at startup are not reported.
+func (t *rolloutTracker) update(old, deployment *appsv1.Deployment) {
+	revision := deployment.Annotations[revisionAnnotation]
+	previous := old.Annotations[revisionAnnotation]
+	key := string(deployment.UID)
+
+	started := revision != previous && revision != ""
+
+	// Informer handlers of one namespace run sequentially, so only the map needs locking
+	t.mu.Lock()
+	state := t.rollouts[key]
+	if started {
+		state = &rolloutState{revision: revision, startedAt: time.Now()}
+		t.rollouts[key] = state
+	}
+	t.mu.Unlock()
+	if state == nil {
+		return
+	}
+	if started {
+		t.emitFor(deployment, "RolloutStarted", v1.EventTypeNormal, t.describeStart(deployment, previous))
+	}
+
+	if rolloutComplete(deployment) {
+		t.mu.Lock()
+		delete(t.rollouts, key)
+		t.mu.Unlock()
+		t.emitFor(deployment, "RolloutCompleted", v1.EventTypeNormal, fmt.Sprintf("deployment %s rolled out revision %s in %s (%d replicas)",
+			deployment.Name, state.revision, time.Since(state.startedAt).Round(time.Second), deployment.Status.UpdatedReplicas))
+		return
+	}
+
+	if condition := deploymentCondition(deployment, appsv1.DeploymentProgressing); condition != nil &&
+		condition.Reason == "ProgressDeadlineExceeded" && !state.stalled {
+		state.stalled = true
+		t.emitFor(deployment, "RolloutStalled", v1.EventTypeWarning, fmt.Sprintf("deployment %s revision %s stalled: %s (%s)",
+			deployment.Name, state.revision, condition.Message, describeProgress(deployment)))
+		return
+	}
+
+	if progress := describeProgress(deployment); progress != state.progress {
+		state.progress = progress
+		t.emitFor(deployment, "RolloutProgress", v1.EventTypeNormal, fmt.Sprintf("deployment %s revision %s: %s",
+			deployment.Name, state.revision, progress))
+	}
+}
+
+// describeStart formats the revision, change cause and image changes of a new rollout
+func (t *rolloutTracker) describeStart(deployment *appsv1.Deployment, previous string) string {
+	message := fmt.Sprintf("deployment %s started rolling out revision %s", deployment.Name, deployment.Annotations[revisionAnnotation])
+	if previous != "" {
+		message += " (from " + previous + ")"
+	}
+	if cause := deployment.Annotations[changeCauseAnnotation]; cause != "" {
+		message += ", change cause: " + cause
+	}
+
+	if rs := t.replicaSet(deployment, previous); rs != nil {
+		if changes := imageChanges(rs.Spec.Template.Spec, deployment.Spec.Template.Spec); len(changes) > 0 {
+			message += ", images: " + strings.Join(changes, ", ")
+		}
+	}
+	return message
+}
+
+// replicaSet returns the ReplicaSet of a deployment with the given revision
+func (t *rolloutTracker) replicaSet(deployment *appsv1.Deployment, revision string) *appsv1.ReplicaSet {
+	if revision == "" {
+		return nil
+	}
+	for _, nw := range t.replicaSets {
+		for _, obj := range nw.informer.GetStore().List() {
+			rs, ok := obj.(*appsv1.ReplicaSet)
+			if !ok || rs.Namespace != deployment.Namespace || rs.Annotations[revisionAnnotation] != revision {
+				continue
+			}
+			for _, owner := range rs.OwnerReferences {
+				if owner.UID == deployment.UID {
+					return rs
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// imageChanges lists the containers whose image differs between two pod specs
+func imageChanges(old, spec v1.PodSpec) []string {
+	images := map[string]string{}
+	for _, container := range podContainers(old) {
+		images[container.Name] = container.Image
+	}
+
+	var changes []string
+	for _, container := range podContainers(spec) {
+		previous, ok := images[container.Name]
+		switch {
+		case !ok:
+			changes = append(changes, fmt.Sprintf("%s added with %s", container.Name, container.Image))
+		case previous != container.Image:
+			changes = append(changes, fmt.Sprintf("%s %s -> %s", container.Name, previous, container.Image))
+		}
+		delete(images, container.Name)
+	}
+	for name := range images {
+		changes = append(changes, name+" removed")
+	}
+	sort.Strings(changes)
+	return changes
+}
+
+// podContainers returns the init and regular containers of a pod spec, without
+// touching the spec, which may belong to an informer cache
+func podContainers(spec v1.PodSpec) []v1.Container {
+	containers := make([]v1.Container, 0, len(spec.InitContainers)+len(spec.Containers))
+	containers = append(containers, spec.InitContainers...)
+	return append(containers, spec.Containers...)
+}
+
+// describeProgress formats the replica counts of a deployment against the desired count
+func describeProgress(deployment *appsv1.Deployment) string {
+	desired := int32(1)
+	if deployment.Spec.Replicas != nil {
+		desired = *deployment.Spec.Replicas
+	}
+	status := deployment.Status
+	return fmt.Sprintf("%d/%d updated, %d/%d ready, %d/%d available",
+		status.UpdatedReplicas, desired, status.ReadyReplicas, desired, status.AvailableReplicas, desired)
+}
+
+// rolloutComplete reports whether a deployment finished rolling out, like kubectl rollout status
+func rolloutComplete(deployment *appsv1.Deployment) bool {
+	desired := int32(1)
+	if deployment.Spec.Replicas != nil {
+		desired = *deployment.Spec.Replicas
+	}
+	status := deployment.Status
+	return status.ObservedGeneration >= deployment.Generation &&
+		status.UpdatedReplicas == desired &&
+		status.Replicas == status.UpdatedReplicas &&
+		status.AvailableReplicas == status.UpdatedReplicas
+}
+
+// deploymentCondition returns the condition of a deployment with the given type, if any
+func deploymentCondition(deployment *appsv1.Deployment, conditionType appsv1.DeploymentConditionType) *appsv1.DeploymentCondition {
+	for i := range deployment.Status.Conditions {
+		if deployment.Status.Conditions[i].Type == conditionType {
+			return &deployment.Status.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// emitFor emits an event about a deployment
+func (t *rolloutTracker) emitFor(deployment *appsv1.Deployment, reason, severity, message string) {
+	t.emit(syntheticEvent("Deployment", deployment, reason, severity, message))
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"fmt"     // Message formatting
+	"sort"    // Ordering problems
+	"strings" // String manipulation
+	"sync"    // Mutual exclusion
+	"time"    // For time-related operations
+
+	v1 "k8s.io/api/core/v1"                       // Core v1 API for Kubernetes
+	discoveryv1 "k8s.io/api/discovery/v1"         // Discovery v1 API for Kubernetes
+	networkingv1 "k8s.io/api/networking/v1"       // Networking v1 API for Kubernetes
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1" // Meta v1 API for Kubernetes
+	"k8s.io/apimachinery/pkg/fields"              // For selecting Kubernetes fields
+	"k8s.io/apimachinery/pkg/labels"              // For matching label selectors
+	"k8s.io/client-go/kubernetes"                 // Kubernetes client
+	"k8s.io/client-go/tools/cache"                // For caching Kubernetes objects
+)
+
+// Interval at which Services and Ingresses are re-examined
+const backendResyncPeriod = time.Minute
+
+// serviceTracker reports silent Service misconfigurations as events: selectors
+// matching no pods, Services losing all their ready endpoints and Ingress rules
+// pointing at missing Services or ports
+type serviceTracker struct {
+	emit      func(*v1.Event)   // Receiver of the synthetic events
+	services  []*namespaceWatch // Services of every watched namespace
+	pods      []*namespaceWatch // Pods of every watched namespace
+	endpoints []*namespaceWatch // EndpointSlices of every watched namespace
+
+	mu              sync.Mutex                 // Guards the fields below
+	emptySelectors  map[string]bool            // Services reported as matching no pods by namespace/name
+	readyEndpoints  map[string]int             // Last ready endpoint count by Service namespace/name
+	noEndpoints     map[string]bool            // Services reported as having no ready endpoints
+	ingressProblems map[string]map[string]bool // Broken backends reported by Ingress namespace/name
+}
+
+// newServiceTracker creates a service tracker
+func newServiceTracker() objectTracker {
+	return &serviceTracker{
+		emptySelectors:  map[string]bool{},
+		readyEndpoints:  map[string]int{},
+		noEndpoints:     map[string]bool{},
+		ingressProblems: map[string]map[string]bool{},
+	}
+}
+
+// start runs Service, Pod, EndpointSlice and Ingress informers for every watched
+// namespace, sharing them with the other trackers
+func (t *serviceTracker) start(w *clusterWatcher, clientset kubernetes.Interface, stop <-chan struct{}) []cache.InformerSynced {
+	t.emit = w.emit
+	restClient := clientset.CoreV1().RESTClient()
+
+	var synced []cache.InformerSynced
+	for _, namespace := range w.namespaces {
+		pods := w.sharedInformer("pods", namespace,
+			cache.NewListWatchFromClient(restClient, "pods", namespace, fields.Everything()),
+			&v1.Pod{}, 0, nil, false)
+		t.pods = append(t.pods, pods)
+
+		services := w.sharedInformer("services", namespace,
+			cache.NewListWatchFromClient(restClient, "services", namespace, fields.Everything()),
+			&v1.Service{}, backendResyncPeriod, cache.ResourceEventHandlerFuncs{
+				AddFunc: func(obj interface{}) {
+					if service, ok := obj.(*v1.Service); ok {
+						t.checkSelector(service)
+					}
+				},
+				UpdateFunc: func(_, obj interface{}) {
+					if service, ok := obj.(*v1.Service); ok {
+						t.checkSelector(service)
+					}
+				},
+				DeleteFunc: func(obj interface{}) {
+					if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+						obj = tombstone.Obj
+					}
+					if service, ok := obj.(*v1.Service); ok {
+						key := service.Namespace + "/" + service.Name
+						t.mu.Lock()
+						delete(t.emptySelectors, key)
+						delete(t.readyEndpoints, key)
+						delete(t.noEndpoints, key)
+						t.mu.Unlock()
+					}
+				},
+			}, true)
+		t.services = append(t.services, services)
+
+		slices := w.sharedInformer("endpointslices", namespace,
+			cache.NewListWatchFromClient(clientset.DiscoveryV1().RESTClient(), "endpointslices", namespace, fields.Everything()),
+			&discoveryv1.EndpointSlice{}, 0, cache.ResourceEventHandlerFuncs{
+				AddFunc:    func(obj interface{}) { t.endpointsChanged(obj) },
+				UpdateFunc: func(_, obj interface{}) { t.endpointsChanged(obj) },
+				DeleteFunc: func(obj interface{}) {
+					if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+						obj = tombstone.Obj
+					}
+					t.endpointsChanged(obj)
+				},
+			}, false)
+		t.endpoints = append(t.endpoints, slices)
+
+		ingresses := w.sharedInformer("ingresses", namespace,
+			cache.NewListWatchFromClient(clientset.NetworkingV1().RESTClient(), "ingresses", namespace, fields.Everything()),
+			&networkingv1.Ingress{}, backendResyncPeriod, cache.ResourceEventHandlerFuncs{
+				AddFunc: func(obj interface{}) {
+					if ingress, ok := obj.(*networkingv1.Ingress); ok {
+						t.checkIngress(ingress)
+					}
+				},
+				UpdateFunc: func(_, obj interface{}) {
+					if ingress, ok := obj.(*networkingv1.Ingress); ok {
+						t.checkIngress(ingress)
+					}
+				},
+				DeleteFunc: func(obj interface{}) {
+					if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+						obj = tombstone.Obj
+					}
+					if ingress, ok := obj.(*networkingv1.Ingress); ok {
+						t.mu.Lock()
+						delete(t.ingressProblems, ingress.Namespace+"/"+ingress.Name)
+						t.mu.Unlock()
+					}
+				},
+			}, true)
+		synced = append(synced, pods.informer.HasSynced, services.informer.HasSynced, slices.informer.HasSynced, ingresses.informer.HasSynced)
+	}
+	return synced
+}
+
+// hasSynced reports whether every informer of the given watches has synced, so
+// that a missing object really is missing
+func hasSynced(watches []*namespaceWatch) bool {
+	for _, nw := range watches {
+		if !nw.informer.HasSynced() {
+			return false
+		}
+	}
+	return true
+}
+
+// checkSelector reports a Service whose selector matches no pods, and the Service
+// matching pods again
+func (t *serviceTracker) checkSelector(service *v1.Service) {
+	if len(service.Spec.Selector) == 0 || service.Spec.Type == v1.ServiceTypeExternalName || !hasSynced(t.pods) {
+		return
+	}
+
+	selector := labels.SelectorFromSet(service.Spec.Selector)
+	var namespacePods []*v1.Pod
+	matching := 0
+	for _, obj := range namespaceObjects(t.pods, service.Namespace) {
+		pod, ok := obj.(*v1.Pod)
+		if !ok {
+			continue
+		}
+		namespacePods = append(namespacePods, pod)
+		if selector.Matches(labels.Set(pod.Labels)) {
+			matching++
+		}
+	}
+
+	key := service.Namespace + "/" + service.Name
+	t.mu.Lock()
+	wasEmpty := t.emptySelectors[key]
+	if matching == 0 {
+		t.emptySelectors[key] = true
+	} else {
+		delete(t.emptySelectors, key)
+	}
+	t.mu.Unlock()
+
+	switch {
+	case matching == 0 && !wasEmpty:
+		t.emitFor(service, "ServiceSelectorMatchesNoPods", v1.EventTypeWarning, fmt.Sprintf("service %s selector %s matches no pods (%s)",
+			service.Name, selector.String(), describeSelectorTerms(service.Spec.Selector, namespacePods)))
+	case matching > 0 && wasEmpty:
+		t.emitFor(service, "ServiceSelectorMatchesPods", v1.EventTypeNormal, fmt.Sprintf("service %s selector %s matches %d pods again",
+			service.Name, selector.String(), matching))
+	}
+}
+
+// describeSelectorTerms counts the pods matching each term of a selector on its own,
+// which shows the term that excludes every pod
+func describeSelectorTerms(selector map[string]string, pods []*v1.Pod) string {
+	keys := make([]string, 0, len(selector))
+	for key := range selector {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	terms := make([]string, 0, len(keys))
+	for _, key := range keys {
+		count := 0
+		for _, pod := range pods {
+			if value, ok := pod.Labels[key]; ok && value == selector[key] {
+				count++
+			}
+		}
+		terms = append(terms, fmt.Sprintf("%s=%s matches %d", key, selector[key], count))
+	}
+	return strings.Join(terms, ", ")
+}
+
+// endpointsChanged recounts the ready endpoints of the Service of a changed
+// EndpointSlice and reports the Service losing all of them, and getting them back
+func (t *serviceTracker) endpointsChanged(obj interface{}) {
+	slice, ok := obj.(*discoveryv1.EndpointSlice)
+	if !ok || slice.Labels[discoveryv1.LabelServiceName] == "" {
+		return
+	}
+	name := slice.Labels[discoveryv1.LabelServiceName]
+	key := slice.Namespace + "/" + name
+
+	ready, notReady := 0, 0
+	for _, item := range namespaceObjects(t.endpoints, slice.Namespace) {
+		s, ok := item.(*discoveryv1.EndpointSlice)
+		if !ok || s.Labels[discoveryv1.LabelServiceName] != name {
+			continue
+		}
+		for _, endpoint := range s.Endpoints {
+			// A nil ready condition means ready
+			if endpoint.Conditions.Ready == nil || *endpoint.Conditions.Ready {
+				ready++
+			} else {
+				notReady++
+			}
+		}
+	}
+
+	t.mu.Lock()
+	previous, known := t.readyEndpoints[key]
+	t.readyEndpoints[key] = ready
+	wasDown := t.noEndpoints[key]
+	down := known && previous > 0 && ready == 0
+	if down {
+		t.noEndpoints[key] = true
+	}
+	up := wasDown && ready > 0
+	if up {
+		delete(t.noEndpoints, key)
+	}
+	t.mu.Unlock()
+
+	service := t.service(slice.Namespace, name)
+	if _, exists := service.(*v1.Service); !exists && hasSynced(t.services) {
+		// The slices of a deleted Service go away with it
+		t.mu.Lock()
+		delete(t.readyEndpoints, key)
+		delete(t.noEndpoints, key)
+		t.mu.Unlock()
+		return
+	}
+	switch {
+	case down:
+		t.emitFor(service, "ServiceEndpointsNotReady", v1.EventTypeWarning, fmt.Sprintf("service %s has no ready endpoints, down from %d (%d not ready)",
+			name, previous, notReady))
+	case up:
+		t.emitFor(service, "ServiceEndpointsReady", v1.EventTypeNormal, fmt.Sprintf("service %s has %d ready endpoints again", name, ready))
+	}
+}
+
+// service returns a Service from the informer caches, or a stand-in carrying its
+// name when it is gone
+func (t *serviceTracker) service(namespace, name string) metav1.Object {
+	for _, nw := range t.services {
+		if obj, ok, _ := nw.informer.GetStore().GetByKey(namespace + "/" + name); ok {
+			if service, ok := obj.(*v1.Service); ok {
+				return service
+			}
+		}
+	}
+	return &metav1.ObjectMeta{Namespace: namespace, Name: name}
+}
+
+// checkIngress reports the backends of an Ingress that point at missing Services or
+// ports, and those backends being fixed
+func (t *serviceTracker) checkIngress(ingress *networkingv1.Ingress) {
+	if !hasSynced(t.services) {
+		return
+	}
+
+	problems := map[string]bool{}
+	check := func(rule string, backend *networkingv1.IngressBackend) {
+		if backend == nil || backend.Service == nil {
+			return
+		}
+		if problem := t.backendProblem(ingress.Namespace, backend.Service); problem != "" {
+			problems[rule+" -> "+problem] = true
+		}
+	}
+	check("default backend", ingress.Spec.DefaultBackend)
+	for _, rule := range ingress.Spec.Rules {
+		if rule.HTTP == nil {
+			continue
+		}
+		host := rule.Host
+		if host == "" {
+			host = "*"
+		}
+		for i := range rule.HTTP.Paths {
+			path := &rule.HTTP.Paths[i]
+			check(host+path.Path, &path.Backend)
+		}
+	}
+
+	key := ingress.Namespace + "/" + ingress.Name
+	t.mu.Lock()
+	reported := t.ingressProblems[key]
+	t.ingressProblems[key] = problems
+	t.mu.Unlock()
+
+	var added, fixed []string
+	for problem := range problems {
+		if !reported[problem] {
+			added = append(added, problem)
+		}
+	}
+	for problem := range reported {
+		if !problems[problem] {
+			fixed = append(fixed, problem)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(fixed)
+
+	if len(added) > 0 {
+		t.emitFor(ingress, "IngressBackendMissing", v1.EventTypeWarning, fmt.Sprintf("ingress %s routes to missing backends: %s",
+			ingress.Name, strings.Join(added, "; ")))
+	}
+	if len(fixed) > 0 {
+		t.emitFor(ingress, "IngressBackendResolved", v1.EventTypeNormal, fmt.Sprintf("ingress %s backends fixed: %s",
+			ingress.Name, strings.Join(fixed, "; ")))
+	}
+}
+
+// backendProblem describes why an Ingress backend does not resolve to a Service
+// port, empty when it does
+func (t *serviceTracker) backendProblem(namespace string, backend *networkingv1.IngressServiceBackend) string {
+	obj := t.service(namespace, backend.Name)
+	service, ok := obj.(*v1.Service)
+	if !ok {
+		return fmt.Sprintf("service %s does not exist", backend.Name)
+	}
+
+	var ports []string
+	for _, port := range service.Spec.Ports {
+		if (backend.Port.Name != "" && port.Name == backend.Port.Name) || (backend.Port.Name == "" && port.Port == backend.Port.Number) {
+			return ""
+		}
+		if port.Name != "" {
+			ports = append(ports, fmt.Sprintf("%s/%d", port.Name, port.Port))
+		} else {
+			ports = append(ports, fmt.Sprint(port.Port))
+		}
+	}
+
+	port := backend.Port.Name
+	if port == "" {
+		port = fmt.Sprint(backend.Port.Number)
+	}
+	if len(ports) == 0 {
+		return fmt.Sprintf("service %s has no port %s (no ports)", backend.Name, port)
+	}
+	return fmt.Sprintf("service %s has no port %s (ports %s)", backend.Name, port, strings.Join(ports, ", "))
+}
+
+// emitFor emits an event about a Service or Ingress
+func (t *serviceTracker) emitFor(obj metav1.Object, reason, severity, message string) {
+	kind := "Service"
+	if _, ok := obj.(*networkingv1.Ingress); ok {
+		kind = "Ingress"
+	}
+	t.emit(syntheticEvent(kind, obj, reason, severity, message))
+}
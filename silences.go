@@ -0,0 +1,397 @@
+package main
+
+import (
+	"crypto/rand"   // Silence identifiers
+	"encoding/hex"  // Identifier formatting
+	"encoding/json" // For JSON encoding
+	"fmt"           // Error formatting
+	"net/http"      // HTTP server functionalities
+	"path"          // Namespace patterns
+	"sort"          // Ordering silences
+	"strings"       // String manipulation
+	"sync"          // Mutual exclusion
+	"time"          // For time-related operations
+
+	"github.com/sirupsen/logrus" // Package for structured logging
+)
+
+// Silence states
+const (
+	silencePending = "pending" // Starts in the future
+	silenceActive  = "active"  // Suppressing notifications
+	silenceExpired = "expired" // Ended
+)
+
+// Namespace no user is granted explicitly, only allowed to users allowed everywhere
+const allNamespacesMarker = "*"
+
+// Prefix of the identifiers of maintenance windows
+const maintenanceWindowPrefix = "window:"
+
+// Silence suppresses the notifications of the alerts it matches between its start
+// and end, as listed on /api/v1/silences. Maintenance windows are listed as
+// silences for their current or next occurrence.
+type Silence struct {
+	ID        string         `json:"id"`        // Identifier, window:<name> for maintenance windows
+	Matcher   SilenceMatcher `json:"matcher"`   // Alerts suppressed
+	StartsAt  time.Time      `json:"startsAt"`  // Start of the silence
+	EndsAt    time.Time      `json:"endsAt"`    // End of the silence
+	CreatedBy string         `json:"createdBy"` // User who created the silence
+	CreatedAt time.Time      `json:"createdAt"` // When the silence was created
+	Comment   string         `json:"comment"`   // Why the alerts are silenced
+	State     string         `json:"state"`     // pending, active or expired
+}
+
+// maintenanceWindow is a compiled recurring maintenance window
+type maintenanceWindow struct {
+	config   MaintenanceWindow // Window as configured
+	schedule *cronSchedule     // Start times of the window
+}
+
+// silenceStore holds the silences created through the API and the configured
+// maintenance windows. Silences are kept in memory and lost on restart.
+type silenceStore struct {
+	windows   []maintenanceWindow // Recurring maintenance windows
+	retention time.Duration       // How long expired silences stay listed
+
+	mu       sync.Mutex          // Guards silences
+	silences map[string]*Silence // Silences created through the API by ID
+}
+
+// newSilenceStore compiles the maintenance windows
+func newSilenceStore(config SilenceConfig) (*silenceStore, error) {
+	s := &silenceStore{retention: config.ExpiredRetention.Duration, silences: map[string]*Silence{}}
+	names := map[string]bool{}
+	for _, window := range config.MaintenanceWindows {
+		if window.Name == "" || names[window.Name] {
+			return nil, fmt.Errorf("maintenance windows need a unique name")
+		}
+		names[window.Name] = true
+		if window.Duration.Duration <= 0 {
+			return nil, fmt.Errorf("maintenance window %q needs a positive duration", window.Name)
+		}
+		if err := window.Matcher.validate(); err != nil {
+			return nil, fmt.Errorf("maintenance window %q: %w", window.Name, err)
+		}
+		location := time.Local
+		if window.TimeZone != "" {
+			loc, err := time.LoadLocation(window.TimeZone)
+			if err != nil {
+				return nil, fmt.Errorf("maintenance window %q: invalid time zone %q: %w", window.Name, window.TimeZone, err)
+			}
+			location = loc
+		}
+		schedule, err := parseCron(window.Schedule, location)
+		if err != nil {
+			return nil, fmt.Errorf("maintenance window %q: invalid schedule %q: %w", window.Name, window.Schedule, err)
+		}
+		if schedule.every > 0 {
+			return nil, fmt.Errorf("maintenance window %q: @every schedules have no fixed start times", window.Name)
+		}
+		s.windows = append(s.windows, maintenanceWindow{config: window, schedule: schedule})
+	}
+	return s, nil
+}
+
+// validate checks that a matcher is not empty and its namespace patterns are valid
+func (m SilenceMatcher) validate() error {
+	if len(m.Rules)+len(m.Clusters)+len(m.Namespaces)+len(m.Kinds)+len(m.Reasons)+len(m.Severities) == 0 {
+		return fmt.Errorf("matcher must not be empty")
+	}
+	for _, pattern := range m.Namespaces {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid namespace pattern %q", pattern)
+		}
+	}
+	return nil
+}
+
+// matches reports whether a matcher matches an alert. Empty fields match everything.
+func (m SilenceMatcher) matches(alert *Alert) bool {
+	return matchesList(m.Rules, alert.Rule) &&
+		matchesList(m.Clusters, alert.Cluster) &&
+		matchesNamespace(m.Namespaces, alert.Namespace) &&
+		matchesList(m.Kinds, alert.Kind) &&
+		matchesList(m.Reasons, alert.Reason) &&
+		matchesList(m.Severities, alert.Severity)
+}
+
+// occurrence returns the start and end of the occurrence of a window running at
+// now, or of the next one. Both are zero when the window never runs again.
+func (w maintenanceWindow) occurrence(now time.Time) (time.Time, time.Time) {
+	start := w.schedule.next(now.Add(-w.config.Duration.Duration))
+	if start.IsZero() {
+		return start, start
+	}
+	return start, start.Add(w.config.Duration.Duration)
+}
+
+// matching returns the identifiers of the silences and maintenance windows active
+// at now matching an alert, nil when none do
+func (s *silenceStore) matching(alert *Alert, now time.Time) []string {
+	if s == nil {
+		return nil
+	}
+	var ids []string
+	for _, window := range s.windows {
+		start, end := window.occurrence(now)
+		if !start.IsZero() && !start.After(now) && now.Before(end) && window.config.Matcher.matches(alert) {
+			ids = append(ids, maintenanceWindowPrefix+window.config.Name)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, silence := range s.silences {
+		if !silence.StartsAt.After(now) && now.Before(silence.EndsAt) && silence.Matcher.matches(alert) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// add stores a new silence, giving it an identifier
+func (s *silenceStore) add(silence Silence) (Silence, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return Silence{}, err
+	}
+	silence.ID = hex.EncodeToString(id)
+	silence.State = ""
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.silences[silence.ID] = &silence
+	return silence, nil
+}
+
+// expire ends a silence now. It returns false when there is no such silence.
+func (s *silenceStore) expire(id string, now time.Time) (Silence, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	silence, ok := s.silences[id]
+	if !ok {
+		return Silence{}, false
+	}
+	if silence.EndsAt.After(now) {
+		silence.EndsAt = now
+	}
+	if silence.StartsAt.After(now) {
+		silence.StartsAt = now
+	}
+	return *silence, true
+}
+
+// get returns a silence created through the API
+func (s *silenceStore) get(id string) (Silence, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	silence, ok := s.silences[id]
+	if !ok {
+		return Silence{}, false
+	}
+	return *silence, true
+}
+
+// list returns the maintenance windows and the silences with their state at now,
+// active first, forgetting silences expired for longer than the retention
+func (s *silenceStore) list(now time.Time) []Silence {
+	silences := []Silence{}
+	for _, window := range s.windows {
+		start, end := window.occurrence(now)
+		silences = append(silences, Silence{
+			ID:        maintenanceWindowPrefix + window.config.Name,
+			Matcher:   window.config.Matcher,
+			StartsAt:  start,
+			EndsAt:    end,
+			CreatedBy: "config",
+			Comment:   window.config.Comment,
+			State:     silenceState(start, end, now),
+		})
+	}
+
+	s.mu.Lock()
+	for id, silence := range s.silences {
+		if now.Sub(silence.EndsAt) > s.retention {
+			delete(s.silences, id)
+			continue
+		}
+		listed := *silence
+		listed.State = silenceState(silence.StartsAt, silence.EndsAt, now)
+		silences = append(silences, listed)
+	}
+	s.mu.Unlock()
+
+	order := map[string]int{silenceActive: 0, silencePending: 1, silenceExpired: 2}
+	sort.Slice(silences, func(i, j int) bool {
+		if order[silences[i].State] != order[silences[j].State] {
+			return order[silences[i].State] < order[silences[j].State]
+		}
+		return silences[i].StartsAt.Before(silences[j].StartsAt)
+	})
+	return silences
+}
+
+// silenceState returns the state at now of a silence running from start to end
+func silenceState(start, end, now time.Time) string {
+	switch {
+	case start.IsZero() || !now.Before(end):
+		return silenceExpired
+	case start.After(now):
+		return silencePending
+	}
+	return silenceActive
+}
+
+// silenceVisible reports whether a user with access may see and change a silence:
+// with namespace authorization, only silences restricted to their namespaces.
+// Namespace patterns may match namespaces created later, so only users allowed in
+// every namespace may use them.
+func silenceVisible(access *namespaceAccess, matcher SilenceMatcher) bool {
+	if access == nil || access.allows(allNamespacesMarker) {
+		return true
+	}
+	if len(matcher.Namespaces) == 0 || namespacePattern(matcher) != "" {
+		return false
+	}
+	for _, namespace := range matcher.Namespaces {
+		if !access.allows(namespace) {
+			return false
+		}
+	}
+	return true
+}
+
+// namespacePattern returns the first namespace of a matcher that is a pattern,
+// empty when they are all names
+func namespacePattern(matcher SilenceMatcher) string {
+	for _, namespace := range matcher.Namespaces {
+		if strings.ContainsAny(namespace, "*?[") {
+			return namespace
+		}
+	}
+	return ""
+}
+
+// silenceRequest is the body of a silence creation request
+type silenceRequest struct {
+	Matcher   SilenceMatcher `json:"matcher"`   // Alerts to suppress
+	StartsAt  time.Time      `json:"startsAt"`  // Start of the silence, now when omitted
+	EndsAt    time.Time      `json:"endsAt"`    // End of the silence
+	Duration  string         `json:"duration"`  // Length of the silence, instead of the end
+	CreatedBy string         `json:"createdBy"` // Creator, the authenticated user when authentication is enabled
+	Comment   string         `json:"comment"`   // Why the alerts are silenced
+}
+
+// handleSilences lists silences on GET and creates them on POST. Silences are
+// expired with DELETE /api/v1/silences/<id>.
+func handleSilences(w http.ResponseWriter, r *http.Request, store *silenceStore, authz namespaceAuthorizer) {
+	var sub subscription
+	if !authorizeRequest(r.Context(), w, r, authz, &sub) {
+		return
+	}
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/silences"), "/")
+	now := time.Now()
+
+	switch {
+	case r.Method == http.MethodGet && id == "":
+		silences := []Silence{}
+		for _, silence := range store.list(now) {
+			if silenceVisible(sub.Access, silence.Matcher) {
+				silences = append(silences, silence)
+			}
+		}
+		writeJSON(w, http.StatusOK, silences)
+
+	case r.Method == http.MethodPost && id == "":
+		var request silenceRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(&request); err != nil {
+			http.Error(w, "Invalid silence: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		silence, err := newSilence(request, requestUser(r), now)
+		if err != nil {
+			http.Error(w, "Invalid silence: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !silenceVisible(sub.Access, silence.Matcher) {
+			message := "Forbidden"
+			if pattern := namespacePattern(silence.Matcher); pattern != "" {
+				message = fmt.Sprintf("Forbidden: namespace pattern %q needs access to every namespace, list the namespaces instead", pattern)
+			} else if len(silence.Matcher.Namespaces) == 0 {
+				message = "Forbidden: silences without namespaces need access to every namespace"
+			}
+			http.Error(w, message, http.StatusForbidden)
+			return
+		}
+		if silence, err = store.add(silence); err != nil {
+			log.WithField("error", err).Error("Failed to create silence")
+			http.Error(w, "Failed to create silence", http.StatusInternalServerError)
+			return
+		}
+		silence.State = silenceState(silence.StartsAt, silence.EndsAt, now)
+		log.WithFields(logrus.Fields{"silence": silence.ID, "createdBy": silence.CreatedBy, "endsAt": silence.EndsAt.Format(time.RFC3339)}).Info("Silence created")
+		writeJSON(w, http.StatusCreated, silence)
+
+	case r.Method == http.MethodDelete && id != "":
+		if silence, ok := store.get(id); !ok || !silenceVisible(sub.Access, silence.Matcher) {
+			http.Error(w, "Silence not found", http.StatusNotFound)
+			return
+		}
+		silence, _ := store.expire(id, now)
+		silence.State = silenceExpired
+		log.WithFields(logrus.Fields{"silence": id}).Info("Silence expired")
+		writeJSON(w, http.StatusOK, silence)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// newSilence checks a silence creation request. The authenticated user, when
+// there is one, is the creator.
+func newSilence(request silenceRequest, user *userInfo, now time.Time) (Silence, error) {
+	silence := Silence{
+		Matcher:   request.Matcher,
+		StartsAt:  request.StartsAt,
+		EndsAt:    request.EndsAt,
+		CreatedBy: request.CreatedBy,
+		CreatedAt: now,
+		Comment:   request.Comment,
+	}
+	if user != nil {
+		silence.CreatedBy = user.Username
+	}
+	if err := silence.Matcher.validate(); err != nil {
+		return Silence{}, err
+	}
+	if silence.CreatedBy == "" || silence.Comment == "" {
+		return Silence{}, fmt.Errorf("createdBy and comment are required")
+	}
+	if silence.StartsAt.IsZero() {
+		silence.StartsAt = now
+	}
+	if request.Duration != "" {
+		duration, err := time.ParseDuration(request.Duration)
+		if err != nil || duration <= 0 || !request.EndsAt.IsZero() {
+			return Silence{}, fmt.Errorf("duration must be a positive duration given instead of endsAt")
+		}
+		silence.EndsAt = silence.StartsAt.Add(duration)
+	}
+	if !silence.EndsAt.After(silence.StartsAt) || !silence.EndsAt.After(now) {
+		return Silence{}, fmt.Errorf("endsAt must be in the future and after startsAt")
+	}
+	return silence, nil
+}
+
+// writeJSON writes a value as indented JSON with a status code
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(value); err != nil {
+		log.WithField("error", err).Warning("Failed to write response")
+	}
+}
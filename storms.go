@@ -0,0 +1,258 @@
+package main
+
+import (
+	"fmt"     // Message formatting
+	"strconv" // Formatting counts
+	"strings" // String manipulation
+	"sync"    // Mutual exclusion
+	"time"    // For time-related operations
+
+	v1 "k8s.io/api/core/v1" // Core v1 API for Kubernetes
+)
+
+// Event type of the storm notifications published instead of the events of a storm
+const stormEventType = "STORM"
+
+// Distinct objects remembered per roll-up, beyond which they are only counted
+const stormMaxObjects = 10000
+
+// Kind of the storm notifications about a workload guessed from object names
+const stormWorkloadKind = "Workload"
+
+// StormSummary describes an event storm in storm notifications
+type StormSummary struct {
+	Reason  string    `json:"reason"`  // Reason of the events of the storm
+	Count   int       `json:"count"`   // Events since the previous notification
+	Objects int       `json:"objects"` // Distinct objects of these events
+	Total   int       `json:"total"`   // Events since the storm started
+	Since   time.Time `json:"since"`   // When the storm started
+}
+
+// stormKey tracks the rate of the events of one namespace, workload and reason,
+// and the storm they form when too many occur
+type stormKey struct {
+	cluster     string      // Cluster of the events
+	namespace   string      // Namespace of the events
+	kind        string      // Kind of the involved objects
+	workload    string      // Workload of the involved objects
+	reason      string      // Reason of the events
+	occurrences []time.Time // Times of the events within the window
+	storm       *storm      // Storm in progress, nil when the events are streamed
+}
+
+// storm is an event storm in progress, whose events are rolled up
+type storm struct {
+	since      time.Time       // When the storm started
+	rolledUpAt time.Time       // When the events were last rolled up
+	severity   string          // Severity of the last event
+	count      int             // Events since the last roll-up
+	total      int             // Events since the storm started
+	objects    map[string]bool // Distinct objects since the last roll-up
+	extra      int             // Objects not remembered since the last roll-up
+}
+
+// stormDetector tracks the rate of the streamed events by namespace, workload and
+// reason. Keys exceeding the threshold switch to storm mode: their events are
+// replaced by periodic roll-ups until they calm down.
+type stormDetector struct {
+	threshold int           // Events within the window starting a storm, 0 to disable detection
+	window    time.Duration // Window of the rates and interval of the roll-ups
+	publish   func(Event)   // Receiver of the storm notifications
+
+	mu   sync.Mutex           // Guards keys
+	keys map[string]*stormKey // Tracked keys by cluster, namespace, workload and reason
+}
+
+// newStormDetector creates a storm detector publishing its notifications to publish
+func newStormDetector(config StormConfig, publish func(Event)) *stormDetector {
+	return &stormDetector{
+		threshold: config.Threshold,
+		window:    config.Window.Duration,
+		publish:   publish,
+		keys:      map[string]*stormKey{},
+	}
+}
+
+// admit counts an event that occurred at the given time and reports whether it is
+// to be streamed, false when it is part of a storm. Events older than the window,
+// such as those listed on startup, are always streamed.
+func (d *stormDetector) admit(event Event, at time.Time) bool {
+	now := time.Now()
+	if d.threshold == 0 || now.Sub(at) > d.window {
+		return true
+	}
+	workload := workloadName(event.Object.Kind, event.Object.Name)
+	id := strings.Join([]string{event.Cluster, event.Object.Namespace, event.Object.Kind, workload, event.Object.Reason}, "/")
+
+	d.mu.Lock()
+	key := d.keys[id]
+	if key == nil {
+		key = &stormKey{cluster: event.Cluster, namespace: event.Object.Namespace, kind: event.Object.Kind, workload: workload, reason: event.Object.Reason}
+		d.keys[id] = key
+	}
+	key.occurrences = append(key.occurrences, at)
+	key.prune(now, d.window)
+
+	var notification *Event
+	if key.storm == nil {
+		if len(key.occurrences) <= d.threshold {
+			d.mu.Unlock()
+			return true
+		}
+		key.storm = &storm{since: now, rolledUpAt: now, objects: map[string]bool{}}
+		started := key.notification("EventStormStarted", event.Object.Severity, fmt.Sprintf(
+			"%s storm %s: %s events in the last %s, rolling them up", key.reason, key.subject(), formatCount(len(key.occurrences)), d.window), now)
+		notification = &started
+	}
+	s := key.storm
+	s.count++
+	s.total++
+	s.severity = event.Object.Severity
+	if len(s.objects) < stormMaxObjects {
+		s.objects[event.Object.Name] = true
+	} else if !s.objects[event.Object.Name] {
+		s.extra++
+	}
+	d.mu.Unlock()
+
+	if notification != nil {
+		d.publish(*notification)
+	}
+	return false
+}
+
+// prune drops the occurrences that left the window
+func (k *stormKey) prune(now time.Time, window time.Duration) {
+	kept := k.occurrences[:0]
+	for _, at := range k.occurrences {
+		if now.Sub(at) <= window {
+			kept = append(kept, at)
+		}
+	}
+	k.occurrences = kept
+}
+
+// run rolls up the storms at every window until stop is closed
+func (d *stormDetector) run(stop <-chan struct{}) {
+	if d.threshold == 0 {
+		return
+	}
+	ticker := time.NewTicker(d.window)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			d.rollUp(now)
+		case <-stop:
+			return
+		}
+	}
+}
+
+// rollUp publishes a roll-up of the events of every storm since the previous one,
+// ends the storms whose rate fell to half the threshold and forgets idle keys
+func (d *stormDetector) rollUp(now time.Time) {
+	var notifications []Event
+
+	d.mu.Lock()
+	for id, key := range d.keys {
+		key.prune(now, d.window)
+		s := key.storm
+		if s == nil {
+			if len(key.occurrences) == 0 {
+				delete(d.keys, id)
+			}
+			continue
+		}
+
+		if s.count > 0 {
+			notifications = append(notifications, key.notification("EventStormRollup", s.severity, fmt.Sprintf(
+				"%s ×%s %s in the last %s", key.reason, formatCount(s.count), key.spread(len(s.objects)+s.extra), now.Sub(s.rolledUpAt).Round(time.Second)), now))
+		}
+		if len(key.occurrences) <= d.threshold/2 {
+			notifications = append(notifications, key.notification("EventStormEnded", v1.EventTypeNormal, fmt.Sprintf(
+				"%s storm %s ended after %s: %s events rolled up, individual events resume", key.reason, key.subject(),
+				now.Sub(s.since).Round(time.Second), formatCount(s.total)), now))
+			key.storm = nil
+			continue
+		}
+		s.rolledUpAt = now
+		s.count, s.extra = 0, 0
+		s.objects = map[string]bool{}
+	}
+	d.mu.Unlock()
+
+	for _, notification := range notifications {
+		d.publish(notification)
+	}
+}
+
+// notification returns a storm notification about the key, summarizing the
+// events since the last roll-up
+func (k *stormKey) notification(reason, severity, message string, now time.Time) Event {
+	s := k.storm
+	kind := k.kind
+	if k.guessed() {
+		kind = stormWorkloadKind
+	}
+	return Event{
+		Type:    stormEventType,
+		Cluster: k.cluster,
+		Object: Object{
+			Kind:      kind,
+			Name:      k.workload,
+			Namespace: k.namespace,
+			Reason:    reason,
+			Severity:  severity,
+			Message:   message,
+		},
+		Timestamp: now.Format(timestampLayout),
+		Storm: &StormSummary{
+			Reason:  k.reason,
+			Count:   s.count,
+			Objects: len(s.objects) + s.extra,
+			Total:   s.total,
+			Since:   s.since,
+		},
+	}
+}
+
+// guessed reports whether the workload of a key is guessed from the generated
+// names of its objects rather than being the object itself
+func (k *stormKey) guessed() bool {
+	return k.kind == "Pod" || k.kind == "ReplicaSet"
+}
+
+// subject names the objects of a key, such as "for pods of workload fluentd"
+func (k *stormKey) subject() string {
+	if k.guessed() {
+		return "for " + strings.ToLower(k.kind) + "s of workload " + k.workload
+	}
+	return "for " + strings.ToLower(k.kind) + " " + k.workload
+}
+
+// spread describes how many objects the events of a roll-up came from, such as
+// "across 86 pods of workload fluentd"
+func (k *stormKey) spread(objects int) string {
+	if !k.guessed() {
+		return k.subject()
+	}
+	noun := strings.ToLower(k.kind)
+	if objects != 1 {
+		noun += "s"
+	}
+	return fmt.Sprintf("across %s %s of workload %s", formatCount(objects), noun, k.workload)
+}
+
+// formatCount formats a count with thousands separators, such as 1,240
+func formatCount(n int) string {
+	digits := strconv.Itoa(n)
+	var b strings.Builder
+	for i, digit := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(digit)
+	}
+	return b.String()
+}
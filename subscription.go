@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http" // HTTP request access
+	"strings"  // String manipulation
+)
+
+// subscription holds the filters a client requested when connecting to /ws.
+// Each filter is a set of accepted values; an empty set accepts everything.
+// Access is set by the server and restricts the namespaces regardless of the filters.
+type subscription struct {
+	Access     *namespaceAccess // Namespaces the user is authorized for, nil for all
+	Clusters   map[string]bool  // Accepted clusters
+	Namespaces map[string]bool  // Accepted namespaces
+	Kinds      map[string]bool  // Accepted involved object kinds
+	Types      map[string]bool  // Accepted event types (ADDED, ...)
+}
+
+// parseSubscription reads the subscription filters from the query string,
+// e.g. /ws?cluster=eu-1&namespace=prod,staging&kind=Pod&type=ADDED
+func parseSubscription(r *http.Request) subscription {
+	query := r.URL.Query()
+	return subscription{
+		Clusters:   parseFilterValues(query["cluster"]),
+		Namespaces: parseFilterValues(query["namespace"]),
+		Kinds:      parseFilterValues(query["kind"]),
+		Types:      parseFilterValues(query["type"]),
+	}
+}
+
+// parseFilterValues turns repeated and comma-separated query values into a set
+func parseFilterValues(values []string) map[string]bool {
+	set := map[string]bool{}
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				set[item] = true
+			}
+		}
+	}
+	return set
+}
+
+// matchesFilter reports whether value is accepted by a filter set
+func matchesFilter(set map[string]bool, value string) bool {
+	return len(set) == 0 || set[value]
+}
+
+// matches reports whether an event passes the authorization and all subscription filters
+func (s subscription) matches(event Event) bool {
+	if s.Access != nil && !s.Access.allows(event.Object.Namespace) {
+		return false
+	}
+	return matchesFilter(s.Clusters, event.Cluster) &&
+		matchesFilter(s.Namespaces, event.Object.Namespace) &&
+		matchesFilter(s.Kinds, event.Object.Kind) &&
+		matchesFilter(s.Types, event.Type)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"       // Comparing file contents
+	"crypto/tls"  // TLS serving
+	"crypto/x509" // Client certificate verification
+	"fmt"         // Error formatting
+	"net/http"    // HTTP server functionalities
+	"os"          // Reading certificate files
+	"sync"        // Mutual exclusion
+	"time"        // For time-related operations
+
+	"github.com/sirupsen/logrus" // Package for structured logging
+)
+
+// Client certificate modes
+const (
+	clientAuthNone     = ""         // Client certificates are not requested
+	clientAuthOptional = "optional" // Certificates are verified when presented
+	clientAuthRequire  = "require"  // Every client must present a valid certificate
+)
+
+// certReloader serves the certificate and client CAs of files that are checked
+// for changes periodically, so rotated Secret mounts are picked up without a restart
+type certReloader struct {
+	certFile, keyFile, caFile string // Watched files
+
+	mu        sync.RWMutex     // Guards the fields below
+	cert      *tls.Certificate // Current serving certificate
+	clientCAs *x509.CertPool   // Current client CAs, nil without client verification
+	contents  [][]byte         // Contents of the files the current state was loaded from
+}
+
+// newCertReloader loads the files once, failing when they are invalid
+func newCertReloader(certFile, keyFile, caFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile, caFile: caFile}
+	if _, err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// reload reads the files and swaps the certificate and CAs when they changed,
+// keeping the previous ones when the new files are invalid
+func (r *certReloader) reload() (bool, error) {
+	files := []string{r.certFile, r.keyFile}
+	if r.caFile != "" {
+		files = append(files, r.caFile)
+	}
+	contents := make([][]byte, len(files))
+	for i, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return false, err
+		}
+		contents[i] = data
+	}
+
+	r.mu.RLock()
+	unchanged := r.contents != nil && len(r.contents) == len(contents)
+	for i := range contents {
+		unchanged = unchanged && bytes.Equal(r.contents[i], contents[i])
+	}
+	r.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.X509KeyPair(contents[0], contents[1])
+	if err != nil {
+		return false, fmt.Errorf("invalid TLS certificate or key: %w", err)
+	}
+	var clientCAs *x509.CertPool
+	if r.caFile != "" {
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(contents[2]) {
+			return false, fmt.Errorf("no certificate found in client CA file %s", r.caFile)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert, r.clientCAs, r.contents = &cert, clientCAs, contents
+	return true, nil
+}
+
+// watch reloads the files every interval until stop is closed
+func (r *certReloader) watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		changed, err := r.reload()
+		if err != nil {
+			log.WithField("error", err).Warning("Failed to reload TLS certificate, keeping the previous one")
+			continue
+		}
+		if changed {
+			log.WithField("certFile", r.certFile).Info("Reloaded TLS certificate")
+		}
+	}
+}
+
+// getCertificate returns the current serving certificate
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// newTLSConfig creates the server TLS configuration, nil when TLS is disabled,
+// and reloads its files until stop is closed
+func newTLSConfig(cfg *Config, stop <-chan struct{}) (*tls.Config, error) {
+	if cfg.TLS.CertFile == "" {
+		return nil, nil
+	}
+	reloader, err := newCertReloader(cfg.TLS.CertFile, cfg.TLS.KeyFile, cfg.TLS.ClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	go reloader.watch(cfg.TLS.ReloadInterval.Duration, stop)
+
+	clientAuth := tls.NoClientCert
+	switch cfg.TLS.ClientAuth {
+	case clientAuthOptional:
+		clientAuth = tls.VerifyClientCertIfGiven
+	case clientAuthRequire:
+		clientAuth = tls.RequireAndVerifyClientCert
+	}
+	base := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.getCertificate,
+		ClientAuth:     clientAuth,
+	}
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// Go 1.20 only serves TLS without certificate files when the outer config
+		// has a certificate source, even though every handshake uses the per-client one
+		GetCertificate: reloader.getCertificate,
+		// Client CAs are read per handshake so that a rotated CA bundle applies to new connections
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			config := base.Clone()
+			reloader.mu.RLock()
+			config.ClientCAs = reloader.clientCAs
+			reloader.mu.RUnlock()
+			return config, nil
+		},
+	}, nil
+}
+
+// certificateUser returns the identity of the verified client certificate of a
+// request: the common name, or the first DNS, email or URI SAN without one, with
+// the organizations as groups
+func certificateUser(r *http.Request) *userInfo {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+
+	username := cert.Subject.CommonName
+	switch {
+	case username != "":
+	case len(cert.DNSNames) > 0:
+		username = cert.DNSNames[0]
+	case len(cert.EmailAddresses) > 0:
+		username = cert.EmailAddresses[0]
+	case len(cert.URIs) > 0:
+		username = cert.URIs[0].String()
+	default:
+		return nil
+	}
+	log.WithFields(logrus.Fields{
+		"user":   username,
+		"serial": cert.SerialNumber.String(),
+	}).Debug("Authenticated client certificate")
+	return &userInfo{Username: username, Groups: cert.Subject.Organization}
+}
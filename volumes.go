@@ -0,0 +1,369 @@
+package main
+
+import (
+	"fmt"     // Message formatting
+	"strings" // String manipulation
+	"sync"    // Mutual exclusion
+	"time"    // For time-related operations
+
+	v1 "k8s.io/api/core/v1"                       // Core v1 API for Kubernetes
+	storagev1 "k8s.io/api/storage/v1"             // Storage v1 API for Kubernetes
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1" // Meta v1 API for Kubernetes
+	"k8s.io/apimachinery/pkg/fields"              // For selecting Kubernetes fields
+	"k8s.io/client-go/kubernetes"                 // Kubernetes client
+	"k8s.io/client-go/tools/cache"                // For caching Kubernetes objects
+)
+
+// Interval at which pending claims are re-examined
+const claimResyncPeriod = time.Minute
+
+// Annotations maintained by the volume controllers
+const (
+	defaultStorageClassAnnotation = "storageclass.kubernetes.io/is-default-class"
+	selectedNodeAnnotation        = "volume.kubernetes.io/selected-node"
+	noProvisioner                 = "kubernetes.io/no-provisioner"
+)
+
+// volumeTracker reports the lifecycle of PersistentVolumes and claims as events:
+// binding, unbinding, resizing and claims pending for too long, with a diagnosis
+// built from the storage class and the pods consuming the claim
+type volumeTracker struct {
+	emit           func(*v1.Event)     // Receiver of the synthetic events
+	config         VolumeWatcherConfig // Thresholds
+	storageClasses *namespaceWatch     // Storage classes of the cluster
+	nodes          *namespaceWatch     // Nodes of the cluster, for their zones
+	pods           []*namespaceWatch   // Pods of every watched namespace
+
+	mu              sync.Mutex        // Guards pendingReported
+	pendingReported map[string]string // Last diagnosis reported by claim UID
+}
+
+// newVolumeTracker creates a volume tracker using the volumes settings
+func newVolumeTracker() objectTracker {
+	return &volumeTracker{config: cfg.Volumes, pendingReported: map[string]string{}}
+}
+
+// start runs claim and pod informers for every watched namespace, and volume,
+// storage class and node informers for the cluster, sharing them with the other trackers
+func (t *volumeTracker) start(w *clusterWatcher, clientset kubernetes.Interface, stop <-chan struct{}) []cache.InformerSynced {
+	t.emit = w.emit
+	restClient := clientset.CoreV1().RESTClient()
+
+	t.storageClasses = w.sharedInformer("storageclasses", metav1.NamespaceAll,
+		cache.NewListWatchFromClient(clientset.StorageV1().RESTClient(), "storageclasses", metav1.NamespaceAll, fields.Everything()),
+		&storagev1.StorageClass{}, 0, nil, false)
+	t.nodes = w.sharedInformer("nodes", metav1.NamespaceAll,
+		cache.NewListWatchFromClient(restClient, "nodes", metav1.NamespaceAll, fields.Everything()),
+		&v1.Node{}, 0, nil, false)
+	synced := []cache.InformerSynced{t.storageClasses.informer.HasSynced, t.nodes.informer.HasSynced}
+
+	volumes := w.sharedInformer("persistentvolumes", metav1.NamespaceAll,
+		cache.NewListWatchFromClient(restClient, "persistentvolumes", metav1.NamespaceAll, fields.Everything()),
+		&v1.PersistentVolume{}, 0, cache.ResourceEventHandlerFuncs{
+			UpdateFunc: func(old, obj interface{}) {
+				oldVolume, ok1 := old.(*v1.PersistentVolume)
+				volume, ok2 := obj.(*v1.PersistentVolume)
+				if ok1 && ok2 && oldVolume.ResourceVersion != volume.ResourceVersion {
+					t.updateVolume(oldVolume, volume)
+				}
+			},
+		}, true)
+	synced = append(synced, volumes.informer.HasSynced)
+
+	for _, namespace := range w.namespaces {
+		pods := w.sharedInformer("pods", namespace,
+			cache.NewListWatchFromClient(restClient, "pods", namespace, fields.Everything()),
+			&v1.Pod{}, 0, nil, false)
+		t.pods = append(t.pods, pods)
+
+		claims := w.sharedInformer("persistentvolumeclaims", namespace,
+			cache.NewListWatchFromClient(restClient, "persistentvolumeclaims", namespace, fields.Everything()),
+			&v1.PersistentVolumeClaim{}, claimResyncPeriod, cache.ResourceEventHandlerFuncs{
+				UpdateFunc: func(old, obj interface{}) {
+					oldClaim, ok1 := old.(*v1.PersistentVolumeClaim)
+					claim, ok2 := obj.(*v1.PersistentVolumeClaim)
+					if ok1 && ok2 {
+						t.updateClaim(oldClaim, claim)
+					}
+				},
+				DeleteFunc: func(obj interface{}) {
+					if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+						obj = tombstone.Obj
+					}
+					if claim, ok := obj.(*v1.PersistentVolumeClaim); ok {
+						t.mu.Lock()
+						delete(t.pendingReported, string(claim.UID))
+						t.mu.Unlock()
+					}
+				},
+			}, true)
+		synced = append(synced, pods.informer.HasSynced, claims.informer.HasSynced)
+	}
+	return synced
+}
+
+// updateClaim reports a claim binding, losing its volume or being resized, and
+// re-examines pending claims on every resync
+func (t *volumeTracker) updateClaim(old, claim *v1.PersistentVolumeClaim) {
+	if old.ResourceVersion != claim.ResourceVersion {
+		t.diffClaimPhase(old, claim)
+		t.diffResize(old, claim)
+	}
+	t.checkPending(claim)
+}
+
+// diffClaimPhase reports claims being bound and losing their volume
+func (t *volumeTracker) diffClaimPhase(old, claim *v1.PersistentVolumeClaim) {
+	if old.Status.Phase == claim.Status.Phase {
+		return
+	}
+
+	switch claim.Status.Phase {
+	case v1.ClaimBound:
+		capacity := claim.Status.Capacity[v1.ResourceStorage]
+		message := fmt.Sprintf("claim %s bound to volume %s (%s", claim.Name, claim.Spec.VolumeName, capacity.String())
+		if class := claimStorageClass(claim); class != "" {
+			message += ", storage class " + class
+		}
+		message += ")"
+		if old.Status.Phase == v1.ClaimPending {
+			message += fmt.Sprintf(" after %s pending", time.Since(claim.CreationTimestamp.Time).Round(time.Second))
+		}
+		t.mu.Lock()
+		delete(t.pendingReported, string(claim.UID))
+		t.mu.Unlock()
+		t.emitFor(claim, "ClaimBound", v1.EventTypeNormal, message)
+	case v1.ClaimLost:
+		t.emitFor(claim, "ClaimLost", v1.EventTypeWarning, fmt.Sprintf("claim %s lost its volume %s, which no longer exists or is bound to another claim",
+			claim.Name, claim.Spec.VolumeName))
+	}
+}
+
+// diffResize reports the progress of a claim expansion: requested, expanding the
+// volume, waiting for the file system expansion, failed and done
+func (t *volumeTracker) diffResize(old, claim *v1.PersistentVolumeClaim) {
+	oldRequest := old.Spec.Resources.Requests[v1.ResourceStorage]
+	request := claim.Spec.Resources.Requests[v1.ResourceStorage]
+	oldCapacity := old.Status.Capacity[v1.ResourceStorage]
+	capacity := claim.Status.Capacity[v1.ResourceStorage]
+
+	if request.Cmp(oldRequest) > 0 && request.Cmp(capacity) > 0 && claim.Status.Phase == v1.ClaimBound {
+		t.emitFor(claim, "ClaimResizeRequested", v1.EventTypeNormal, fmt.Sprintf("claim %s resize requested from %s to %s",
+			claim.Name, capacity.String(), request.String()))
+	}
+
+	for _, condition := range claim.Status.Conditions {
+		if condition.Status != v1.ConditionTrue || claimCondition(old, condition.Type) {
+			continue
+		}
+		switch condition.Type {
+		case v1.PersistentVolumeClaimResizing:
+			t.emitFor(claim, "ClaimResizing", v1.EventTypeNormal, fmt.Sprintf("claim %s: volume %s being expanded to %s",
+				claim.Name, claim.Spec.VolumeName, request.String()))
+		case v1.PersistentVolumeClaimFileSystemResizePending:
+			t.emitFor(claim, "ClaimFileSystemResizePending", v1.EventTypeNormal, fmt.Sprintf("claim %s: volume %s expanded, waiting for the kubelet of a pod using it to expand the file system",
+				claim.Name, claim.Spec.VolumeName))
+		}
+	}
+
+	status := claim.Status.AllocatedResourceStatuses[v1.ResourceStorage]
+	if status != old.Status.AllocatedResourceStatuses[v1.ResourceStorage] &&
+		(status == v1.PersistentVolumeClaimControllerResizeFailed || status == v1.PersistentVolumeClaimNodeResizeFailed) {
+		t.emitFor(claim, "ClaimResizeFailed", v1.EventTypeWarning, fmt.Sprintf("claim %s resize to %s failed: %s",
+			claim.Name, request.String(), status))
+	}
+
+	if !oldCapacity.IsZero() && capacity.Cmp(oldCapacity) > 0 {
+		t.emitFor(claim, "ClaimResized", v1.EventTypeNormal, fmt.Sprintf("claim %s resized from %s to %s",
+			claim.Name, oldCapacity.String(), capacity.String()))
+	}
+}
+
+// claimCondition reports whether a claim has the condition with the given type set to true
+func claimCondition(claim *v1.PersistentVolumeClaim, conditionType v1.PersistentVolumeClaimConditionType) bool {
+	for _, condition := range claim.Status.Conditions {
+		if condition.Type == conditionType {
+			return condition.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// checkPending reports a claim pending for longer than the threshold with the
+// reason it is waiting, again whenever that reason changes
+func (t *volumeTracker) checkPending(claim *v1.PersistentVolumeClaim) {
+	if claim.Status.Phase != v1.ClaimPending || claim.DeletionTimestamp != nil {
+		return
+	}
+	pending := time.Since(claim.CreationTimestamp.Time)
+	if pending < t.config.PendingThreshold.Duration {
+		return
+	}
+
+	diagnosis := t.diagnosePending(claim)
+	key := string(claim.UID)
+	t.mu.Lock()
+	report := t.pendingReported[key] != diagnosis
+	t.pendingReported[key] = diagnosis
+	t.mu.Unlock()
+	if report {
+		t.emitFor(claim, "ClaimPending", v1.EventTypeWarning, fmt.Sprintf("claim %s pending for %s: %s",
+			claim.Name, pending.Round(time.Second), diagnosis))
+	}
+}
+
+// diagnosePending explains why a claim is not bound yet from its storage class and
+// the pods waiting for it
+func (t *volumeTracker) diagnosePending(claim *v1.PersistentVolumeClaim) string {
+	className := claimStorageClass(claim)
+	var class *storagev1.StorageClass
+	if className == "" && claim.Spec.StorageClassName == nil {
+		class = t.defaultStorageClass()
+	} else if className != "" {
+		if obj, ok, _ := t.storageClasses.informer.GetStore().GetByKey(className); ok {
+			class, _ = obj.(*storagev1.StorageClass)
+		}
+	}
+
+	var parts []string
+	switch {
+	case claim.Spec.VolumeName != "":
+		parts = append(parts, "waiting to bind to volume "+claim.Spec.VolumeName)
+	case class == nil && className != "":
+		parts = append(parts, fmt.Sprintf("storage class %s does not exist", className))
+	case class == nil:
+		parts = append(parts, "no storage class, waiting for a matching volume to be created")
+	case class.Provisioner == noProvisioner:
+		parts = append(parts, fmt.Sprintf("storage class %s has no provisioner, waiting for a matching volume to be created", class.Name))
+	case class.VolumeBindingMode != nil && *class.VolumeBindingMode == storagev1.VolumeBindingWaitForFirstConsumer && claim.Annotations[selectedNodeAnnotation] == "":
+		parts = append(parts, "waiting for first consumer")
+	default:
+		message := "waiting for provisioner " + class.Provisioner
+		if node := claim.Annotations[selectedNodeAnnotation]; node != "" {
+			message += " to provision on node " + t.describeNode(node)
+		}
+		parts = append(parts, message)
+	}
+
+	pods := t.consumers(claim)
+	if len(pods) == 0 {
+		parts = append(parts, "no pod uses the claim")
+	}
+	for _, pod := range pods {
+		parts = append(parts, describePendingPod(pod, t.describeNode(pod.Spec.NodeName)))
+	}
+
+	if class != nil {
+		mode := storagev1.VolumeBindingImmediate
+		if class.VolumeBindingMode != nil {
+			mode = *class.VolumeBindingMode
+		}
+		parts = append(parts, fmt.Sprintf("storage class %s (provisioner %s, %s)", class.Name, class.Provisioner, mode))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// describePendingPod formats the scheduling state of a pod waiting for a claim
+func describePendingPod(pod *v1.Pod, node string) string {
+	if pod.Spec.NodeName != "" {
+		return fmt.Sprintf("pod %s is Pending on node %s", pod.Name, node)
+	}
+	message := fmt.Sprintf("pod %s is Pending on scheduling", pod.Name)
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == v1.PodScheduled && condition.Status == v1.ConditionFalse && condition.Message != "" {
+			message += " (" + condition.Message + ")"
+		}
+	}
+	return message
+}
+
+// describeNode formats a node with its zone, when known
+func (t *volumeTracker) describeNode(name string) string {
+	if obj, ok, _ := t.nodes.informer.GetStore().GetByKey(name); ok {
+		if node, ok := obj.(*v1.Node); ok && node.Labels[v1.LabelTopologyZone] != "" {
+			return fmt.Sprintf("%s (zone %s)", name, node.Labels[v1.LabelTopologyZone])
+		}
+	}
+	return name
+}
+
+// consumers returns the pending pods using a claim, directly or through a generic
+// ephemeral volume
+func (t *volumeTracker) consumers(claim *v1.PersistentVolumeClaim) []*v1.Pod {
+	var pods []*v1.Pod
+	for _, nw := range t.pods {
+		for _, obj := range nw.informer.GetStore().List() {
+			// Pods consuming an unbound claim cannot start, so pending pods are enough
+			pod, ok := obj.(*v1.Pod)
+			if !ok || pod.Namespace != claim.Namespace || pod.Status.Phase != v1.PodPending {
+				continue
+			}
+			for _, volume := range pod.Spec.Volumes {
+				if (volume.PersistentVolumeClaim != nil && volume.PersistentVolumeClaim.ClaimName == claim.Name) ||
+					(volume.Ephemeral != nil && pod.Name+"-"+volume.Name == claim.Name) {
+					pods = append(pods, pod)
+					break
+				}
+			}
+		}
+	}
+	return pods
+}
+
+// defaultStorageClass returns the storage class claims without one get, if any
+func (t *volumeTracker) defaultStorageClass() *storagev1.StorageClass {
+	for _, obj := range t.storageClasses.informer.GetStore().List() {
+		if class, ok := obj.(*storagev1.StorageClass); ok && class.Annotations[defaultStorageClassAnnotation] == "true" {
+			return class
+		}
+	}
+	return nil
+}
+
+// claimStorageClass returns the storage class requested by a claim, if any
+func claimStorageClass(claim *v1.PersistentVolumeClaim) string {
+	if claim.Spec.StorageClassName != nil {
+		return *claim.Spec.StorageClassName
+	}
+	return claim.Annotations[v1.BetaStorageClassAnnotation]
+}
+
+// updateVolume reports volumes being released by their claim, becoming available
+// again and failing
+func (t *volumeTracker) updateVolume(old, volume *v1.PersistentVolume) {
+	if old.Status.Phase == volume.Status.Phase {
+		return
+	}
+
+	switch volume.Status.Phase {
+	case v1.VolumeBound:
+		message := "volume " + volume.Name + " bound"
+		if ref := volume.Spec.ClaimRef; ref != nil {
+			message += fmt.Sprintf(" to claim %s/%s", ref.Namespace, ref.Name)
+		}
+		t.emitFor(volume, "VolumeBound", v1.EventTypeNormal, message)
+	case v1.VolumeReleased:
+		message := "volume " + volume.Name + " released"
+		if ref := volume.Spec.ClaimRef; ref != nil {
+			message += fmt.Sprintf(" by claim %s/%s", ref.Namespace, ref.Name)
+		}
+		t.emitFor(volume, "VolumeReleased", v1.EventTypeNormal, fmt.Sprintf("%s (reclaim policy %s)", message, volume.Spec.PersistentVolumeReclaimPolicy))
+	case v1.VolumeAvailable:
+		t.emitFor(volume, "VolumeAvailable", v1.EventTypeNormal, fmt.Sprintf("volume %s available", volume.Name))
+	case v1.VolumeFailed:
+		message := fmt.Sprintf("volume %s failed", volume.Name)
+		if volume.Status.Message != "" {
+			message += ": " + volume.Status.Message
+		}
+		t.emitFor(volume, "VolumeFailed", v1.EventTypeWarning, message)
+	}
+}
+
+// emitFor emits an event about a claim or volume
+func (t *volumeTracker) emitFor(obj metav1.Object, reason, severity, message string) {
+	kind := "PersistentVolumeClaim"
+	if _, ok := obj.(*v1.PersistentVolume); ok {
+		kind = "PersistentVolume"
+	}
+	t.emit(syntheticEvent(kind, obj, reason, severity, message))
+}